@@ -17,15 +17,50 @@
 package main
 
 import (
+	"encoding/hex"
 	"flag"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strings"
 	"zap"
 	"zap/zapped"
 )
 
+// isLibraryFile reports if the file with the provided name is part of the
+// zapped library, and should be copied into the project.
+func isLibraryFile(name string) bool {
+	if !strings.HasSuffix(name, ".go") || strings.HasSuffix(name, "_test.go") {
+		return false
+	}
+
+	return !strings.HasPrefix(name, "zap.embed.")
+}
+
+// readSecret reads the hex encoded key that resources are encrypted with from
+// the file if one is provided, and otherwise from the environment variable.
+func readSecret(file, env string) ([]byte, error) {
+	encoded := os.Getenv(env)
+
+	if file != "" {
+		contents, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		encoded = string(contents)
+	}
+
+	encoded = strings.TrimSpace(encoded)
+	if encoded == "" {
+		return nil, fmt.Errorf("no key was found in %s", env)
+	}
+
+	return hex.DecodeString(encoded)
+}
+
 func main() {
 	// Setup development mode flag.
 	var devMode = flag.Bool(
@@ -34,8 +69,75 @@ func main() {
 		"whether or not zapped should run in development mode.",
 	)
 
+	// Setup flag for leaving out modification times, so that the output is
+	// the same no matter when the files were last touched.
+	var zeroModTimes = flag.Bool(
+		"zeroModTimes",
+		false,
+		"whether or not to leave modification times out of embedded files.",
+	)
+
+	// Setup flags for encrypting resources, so that their contents can't be
+	// read from the binary without the key. The key is never written into the
+	// generated code.
+	var encrypt = flag.String(
+		"encrypt",
+		"",
+		"comma separated keys of the resources to encrypt.",
+	)
+
+	var secretFile = flag.String(
+		"encryptionKeyFile",
+		"",
+		"file containing the hex encoded key to encrypt resources with.",
+	)
+
+	var secretEnv = flag.String(
+		"encryptionKeyEnv",
+		"ZAP_ENCRYPTION_KEY",
+		"environment variable containing the hex encoded key to encrypt "+
+			"resources with, used if no file is provided.",
+	)
+
+	// Setup flags for the build that the project is scanned for, so that only
+	// the resources needed by the files that would be compiled are embedded.
+	var tags = flag.String(
+		"tags",
+		"",
+		"comma separated build tags to consider satisfied while scanning.",
+	)
+
+	var goos = flag.String(
+		"goos",
+		"",
+		"operating system to scan for, defaulting to that of the host.",
+	)
+
+	var goarch = flag.String(
+		"goarch",
+		"",
+		"architecture to scan for, defaulting to that of the host.",
+	)
+
+	var allFiles = flag.Bool(
+		"allFiles",
+		false,
+		"whether or not to scan every file no matter its build constraints, "+
+			"embedding the resources needed by all targets.",
+	)
+
 	flag.Parse()
 
+	scanContext := zap.ScanContext{
+		GOOS:     *goos,
+		GOARCH:   *goarch,
+		AllFiles: *allFiles,
+	}
+
+	if *tags != "" {
+		scanContext.Tags = strings.Split(*tags, ",")
+	}
+
 	// Get the working directory of the program.
 	wd, err := os.Getwd()
 	if err != nil {
@@ -67,21 +169,33 @@ func main() {
 		os.Exit(1)
 	}
 
-	zappedLib, err := zappedResource.File("zapped.go")
-	if err != nil {
-		fmt.Printf(
-			"an error occured while reading zapped.go: %s\n",
-			err.Error(),
-		)
+	// The library is split across several files and packages, so copy over
+	// every Go file in it other than tests and embedded data.
+	err = zappedResource.Walk(func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
 
-		os.Exit(1)
-	}
+		target := filepath.Join(zappedPath, filepath.FromSlash(name))
+		if entry.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+
+		if !isLibraryFile(entry.Name()) {
+			return nil
+		}
+
+		contents, err := zappedResource.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		return ioutil.WriteFile(target, contents, 0666)
+	})
 
-	zappedLibPath := filepath.Join(zappedPath, "zapped.go")
-	err = ioutil.WriteFile(zappedLibPath, zappedLib.Bytes(), 0666)
 	if err != nil {
 		fmt.Printf(
-			"an error occured while writing zapped.go: %s\n",
+			"an error occured while copying the zapped library: %s\n",
 			err.Error(),
 		)
 
@@ -96,7 +210,7 @@ func main() {
 	}
 
 	// Get all the packages in the project.
-	packages, err := zap.GetPackagesInProject(wd)
+	packages, err := zap.GetPackagesInProject(wd, scanContext)
 	if err != nil {
 		fmt.Printf(
 			"an error occured while getting packages in project: %s\n",
@@ -106,10 +220,12 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Get resources in all the packages.
+	// Get resources in all the packages. The importer is shared between them
+	// so that the packages they depend on are only type checked once.
 	var resources []zap.Resource
+	importer := zap.NewImporter(scanContext)
 	for _, pkg := range packages {
-		packageResources, err := zap.GetResourcesInPackage(pkg)
+		packageResources, err := zap.GetResourcesInPackage(pkg, importer)
 		if err != nil {
 			fmt.Printf(
 				"an error occured while getting resources in package %s: %s\n",
@@ -123,8 +239,18 @@ func main() {
 		resources = append(resources, packageResources...)
 	}
 
+	// Check that no key is used for more than one resource.
+	if err := zap.CheckKeys(resources); err != nil {
+		fmt.Printf(
+			"an error occured while checking resource keys: %s\n",
+			err.Error(),
+		)
+
+		os.Exit(1)
+	}
+
 	// Embed the directories.
-	embeddedDirectories, err := zap.EmbedDirectories(resources)
+	embeddedDirectories, err := zap.EmbedDirectories(resources, *zeroModTimes)
 	if err != nil {
 		fmt.Printf(
 			"an error occured while embedding resources: %s\n",
@@ -134,6 +260,30 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Mark the resources that should be encrypted.
+	if *encrypt != "" {
+		secret, err := readSecret(*secretFile, *secretEnv)
+		if err != nil {
+			fmt.Printf(
+				"an error occured while reading the encryption key: %s\n",
+				err.Error(),
+			)
+
+			os.Exit(1)
+		}
+
+		keys := strings.Split(*encrypt, ",")
+		err = zap.EncryptDirectories(embeddedDirectories, keys, secret)
+		if err != nil {
+			fmt.Printf(
+				"an error occured while encrypting resources: %s\n",
+				err.Error(),
+			)
+
+			os.Exit(1)
+		}
+	}
+
 	// Generate the code.
 	code, err := zap.GenerateCode(embeddedDirectories, *devMode)
 	if err != nil {
@@ -145,9 +295,9 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Write the code to the file
-	embedPath := filepath.Join(zappedPath, "zap.embed.go")
-	err = ioutil.WriteFile(embedPath, code, 0666)
+	// Write the code to the files, removing any left over from resources
+	// that no longer exist.
+	err = zap.WriteCode(zappedPath, code)
 	if err != nil {
 		fmt.Printf(
 			"an error occured while writing code: %s\n",
@@ -18,26 +18,43 @@ package zap
 
 import (
 	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
 	"go/ast"
 	"go/build"
+	"go/constant"
 	"go/format"
+	"go/importer"
 	"go/parser"
 	"go/token"
+	"go/types"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
+	"time"
+	"unicode/utf8"
 )
 
 // Resource is used to track each unique Key passed to a call to Resource() and
-// the path specified in the call.
+// the path specified in the call. File is set when the call was to
+// ResourceFile(), in which case the path is a single file to embed rather than
+// a directory. Test is set when the call was made from a test file, and Pos is
+// the position of the call in the source.
 type Resource struct {
 	Key  string
 	Path string
+	File bool
+	Test bool
+	Pos  token.Position
 }
 
 // aggregateError is a collection of errors that fullfils the error interface,
@@ -78,18 +95,156 @@ func (ag aggregateError) Error() string {
 	return str
 }
 
+// File represents an embedded file, along with the information about it that
+// was recorded from the filesystem when it was embedded. Hash is the SHA-256 of
+// the contents, encoded as hexadecimal.
+type File struct {
+	Contents []byte
+	Size     int64
+	Mode     os.FileMode
+	ModTime  time.Time
+	Hash     string
+}
+
 // Directory represents an embedded directory. Only the absolute paths of the
-// subdirectories are stored so that they are not embedded mulitple times.
+// subdirectories are stored so that they are not embedded mulitple times. If
+// the Directory was created for a call to ResourceFile(), File holds the file
+// that was embedded and the Directory has no other contents. If Secret is set,
+// the files are encrypted with it in the generated code, see
+// EncryptDirectories. Test is set when the resource is only used by tests, in
+// which case its code is only compiled with the TestTag build tag.
 type Directory struct {
 	Key     string
 	SubDirs []string
-	Files   map[string][]byte
+	Files   map[string]File
+	File    *File
+	Secret  []byte
+	Test    bool
+}
+
+// ScanContext describes the build that the project is being scanned for, so
+// that only the files that would be compiled into it are searched for calls to
+// Resource(). The zero value scans for the host, as the go tool would build by
+// default.
+type ScanContext struct {
+	// Tags are the build tags to consider satisfied, in addition to those for
+	// the operating system, architecture and Go version.
+	Tags []string
+
+	// GOOS and GOARCH are the target operating system and architecture,
+	// falling back to those of the host when empty.
+	GOOS   string
+	GOARCH string
+
+	// AllFiles scans every Go file no matter its build constraints, so that
+	// the resources needed by all targets are embedded.
+	AllFiles bool
+}
+
+// buildContext returns the build.Context that packages are imported with.
+func (sc ScanContext) buildContext() build.Context {
+	ctxt := build.Default
+
+	if sc.GOOS != "" {
+		ctxt.GOOS = sc.GOOS
+	}
+
+	if sc.GOARCH != "" {
+		ctxt.GOARCH = sc.GOARCH
+	}
+
+	// Like the go tool, cgo is disabled when building for another target
+	// unless it has been explicitly enabled.
+	cross := ctxt.GOOS != build.Default.GOOS ||
+		ctxt.GOARCH != build.Default.GOARCH
+
+	if cross && os.Getenv("CGO_ENABLED") == "" {
+		ctxt.CgoEnabled = false
+	}
+
+	ctxt.BuildTags = append([]string(nil), ctxt.BuildTags...)
+	ctxt.BuildTags = append(ctxt.BuildTags, sc.Tags...)
+	return ctxt
+}
+
+// isNoGoError reports if the error is because a package has no Go files that
+// match the build constraints.
+func isNoGoError(err error) bool {
+	return strings.HasPrefix(err.Error(), "no buildable Go source")
+}
+
+// importPackage imports the package in dir, or with the provided path as it
+// would be imported by a file in dir if path isn't empty. A package with no
+// files that match the build constraints is only imported when all files are
+// being scanned.
+func (sc ScanContext) importPackage(path, dir string) (*build.Package, error) {
+	ctxt := sc.buildContext()
+
+	var pkg *build.Package
+	var err error
+
+	if path == "" {
+		pkg, err = ctxt.ImportDir(dir, 0)
+	} else {
+		// The go command is run from dir so that imports are resolved within
+		// the module that the importing file belongs to.
+		ctxt.Dir = dir
+		pkg, err = ctxt.Import(path, dir, 0)
+	}
+
+	if err != nil && sc.AllFiles && isNoGoError(err) &&
+		len(sc.goFiles(pkg)) != 0 {
+		return pkg, nil
+	}
+
+	return pkg, err
+}
+
+// goFiles returns the names of the Go files in the package that are scanned,
+// apart from tests. When all files are being scanned, that includes the files
+// left out by their build constraints.
+func (sc ScanContext) goFiles(pkg *build.Package) []string {
+	var files []string
+
+	files = append(files, pkg.GoFiles...)
+	files = append(files, pkg.CgoFiles...)
+
+	if sc.AllFiles {
+		for _, file := range pkg.IgnoredGoFiles {
+			if !strings.HasSuffix(file, "_test.go") {
+				files = append(files, file)
+			}
+		}
+	}
+
+	sort.Strings(files)
+	return files
+}
+
+// testGoFiles returns the names of the test files in the package that are
+// scanned, both those in the package and those in the external test package.
+func (sc ScanContext) testGoFiles(pkg *build.Package) []string {
+	var files []string
+
+	files = append(files, pkg.TestGoFiles...)
+	files = append(files, pkg.XTestGoFiles...)
+
+	if sc.AllFiles {
+		for _, file := range pkg.IgnoredGoFiles {
+			if strings.HasSuffix(file, "_test.go") {
+				files = append(files, file)
+			}
+		}
+	}
+
+	sort.Strings(files)
+	return files
 }
 
 // GetPackagesInProject will return the package in the current directory, as
-// well as all the subdirectories under it. Under the hood it uses Walk, so
-// it won't follow symbolic links.
-func GetPackagesInProject(wd string) ([]*build.Package, error) {
+// well as all the subdirectories under it, as they would be built for the
+// ScanContext. Under the hood it uses Walk, so it won't follow symbolic links.
+func GetPackagesInProject(wd string, sc ScanContext) ([]*build.Package, error) {
 	var packages []*build.Package
 
 	fn := func(path string, info os.FileInfo, err error) error {
@@ -102,10 +257,10 @@ func GetPackagesInProject(wd string) ([]*build.Package, error) {
 			return filepath.SkipDir
 		}
 
-		pkg, err := build.ImportDir(path, 0)
+		pkg, err := sc.importPackage("", path)
 
 		if err != nil {
-			if !strings.HasPrefix(err.Error(), "no buildable Go source") {
+			if !isNoGoError(err) {
 				return err
 			}
 
@@ -144,48 +299,137 @@ func getZappedImportName(file *ast.File) string {
 // Track the types of errors that could occur so that generateParseError knows
 // what message to use.
 const (
-	errorUnknownCall uint8 = iota
-	errorBadType
+	errorBadType uint8 = iota
 )
 
 // generateParseError will return an error with correct formatting describing
 // what was incorrect about the scanned source.
 func generateParseError(fset *token.FileSet, p token.Pos, err uint8) error {
-	pos := fset.Position(p)
-	filename := pos.Filename
-
-	// Reduces unecessary duplication of code.
-	format := func(msg string) string {
-		return fmt.Sprintf("%s:%d:%d: %s", filename, pos.Line, pos.Column, msg)
-	}
-
 	var msg string
 	switch err {
-	case errorUnknownCall:
-		msg = format("expected Resource() but was something else")
 	case errorBadType:
-		msg = format("calls to Resource() require string literals")
+		msg = "calls to Resource() require constant strings"
 	}
 
-	return fmt.Errorf(msg)
+	return positionError(fset.Position(p), msg)
+}
+
+// positionError returns an error with the message, prefixed by the position in
+// the source that it is about.
+func positionError(pos token.Position, msg string) error {
+	return fmt.Errorf("%s:%d:%d: %s", pos.Filename, pos.Line, pos.Column, msg)
+}
+
+// constantString returns the value of the expression if it is a constant
+// string. When type information is available it is used to evaluate named
+// constants and constant expressions, otherwise only string literals are
+// understood.
+func constantString(info *types.Info, expr ast.Expr) (string, bool) {
+	if info != nil {
+		if tv, ok := info.Types[expr]; ok && tv.Value != nil {
+			if tv.Value.Kind() != constant.String {
+				return "", false
+			}
+
+			return constant.StringVal(tv.Value), true
+		}
+	}
+
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.STRING {
+		return "", false
+	}
+
+	// Unquote handles both interpreted and raw string literals, decoding any
+	// escape sequences in the former.
+	value, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return "", false
+	}
+
+	return value, true
+}
+
+// isZappedPackage reports if the package with the provided import path is the
+// zapped library.
+func isZappedPackage(path string) bool {
+	return path == "zapped" || strings.HasSuffix(path, "/zapped")
+}
+
+// resourceCall reports if the function being called is Resource() or
+// ResourceFile() from the zapped library, along with which of the two it is.
+// The type information is used to resolve what the identifiers in the call
+// refer to, so that shadowed identifiers aren't mistaken for the library. If it
+// is nil, the library is identified by the name it was imported under.
+func resourceCall(
+	call *ast.CallExpr,
+	imp string,
+	info *types.Info,
+) (ok bool, file bool) {
+	var name *ast.Ident
+
+	switch fun := call.Fun.(type) {
+	case *ast.SelectorExpr:
+		x, ok := fun.X.(*ast.Ident)
+		if !ok {
+			return false, false
+		}
+
+		if info == nil {
+			if x.Name != imp {
+				return false, false
+			}
+		} else {
+			pkg, ok := info.Uses[x].(*types.PkgName)
+			if !ok || !isZappedPackage(pkg.Imported().Path()) {
+				return false, false
+			}
+		}
+
+		name = fun.Sel
+
+	case *ast.Ident:
+		// Calls made through a dot import can only be identified with the type
+		// information, as there is nothing else to tie them to the library.
+		if info == nil {
+			return false, false
+		}
+
+		fn, ok := info.Uses[fun].(*types.Func)
+		if !ok || fn.Pkg() == nil || !isZappedPackage(fn.Pkg().Path()) {
+			return false, false
+		}
+
+		name = fun
+
+	default:
+		return false, false
+	}
+
+	switch name.Name {
+	case "Resource":
+		return true, false
+	case "ResourceFile":
+		return true, true
+	}
+
+	return false, false
 }
 
 // parse will walk the AST and identify calls to Resource() and extract
-// the key and the path from them. It will return an error
-func parse(f *ast.File, fset *token.FileSet, imp string) ([]Resource, error) {
+// the key and the path from them. Any other use of the zapped library is
+// ignored. The type information of the package the file belongs to is used to
+// resolve the calls and evaluate arguments that aren't literals, and may be
+// nil, in which case imp is the name the library was imported under.
+func parse(
+	f *ast.File,
+	fset *token.FileSet,
+	imp string,
+	info *types.Info,
+) ([]Resource, error) {
 	var resources []Resource
 	var errors aggregateError
 
-	// State based parsing let's us solve this problem without needing to have
-	// a million different variables tracking everything.
-	var state uint8
-	const (
-		NilState uint8 = iota
-		ExpectingResourceCall
-		ExpectingKey
-		ExpectingPath
-	)
-
 	// Error handling code being pulled out into it's own closure reduces
 	// repetition of simple code.
 	handleError := func(node ast.Node, errorType uint8) {
@@ -193,102 +437,225 @@ func parse(f *ast.File, fset *token.FileSet, imp string) ([]Resource, error) {
 		errors.Add(err)
 	}
 
-	// Pulling the code out of the switch improves the readability, and ensures
-	// clarity of logic.
-	resolveIdent := func(node *ast.Ident) uint8 {
-		switch state {
-		case NilState:
-			if node.Name == imp {
-				return ExpectingResourceCall
-			}
+	// Walk the AST.
+	ast.Inspect(f, func(node ast.Node) bool {
+		call, ok := node.(*ast.CallExpr)
+		if !ok {
+			return true
+		}
 
-		case ExpectingResourceCall:
-			if node.Name != "Resource" {
-				handleError(node, errorUnknownCall)
-				return NilState
-			}
+		// A call with the wrong number of arguments won't compile, so there is
+		// no need to report it here.
+		ok, file := resourceCall(call, imp, info)
+		if !ok || len(call.Args) != 2 {
+			return true
+		}
+
+		key, ok := constantString(info, call.Args[0])
+		if !ok {
+			handleError(call.Args[0], errorBadType)
+			return true
+		}
 
-			return ExpectingKey
+		res := Resource{Key: key, File: file, Pos: fset.Position(call.Pos())}
 
-		case ExpectingKey, ExpectingPath:
-			handleError(node, errorBadType)
-			return NilState
+		res.Path, ok = constantString(info, call.Args[1])
+		if !ok {
+			handleError(call.Args[1], errorBadType)
 		}
 
-		return state
+		resources = append(resources, res)
+		return true
+	})
+
+	return resources, errors.SafeReturn()
+}
+
+// Importer imports the packages the scanned code depends on so that constants
+// declared in them can be evaluated. Packages in the standard library are
+// loaded with the default importer when scanning for the host, and all others
+// are type checked from source. Imported packages are kept, so one Importer
+// should be used for every package in a scan, letting the packages they share
+// be checked only once.
+type Importer struct {
+	fset     *token.FileSet
+	sc       ScanContext
+	ctxt     build.Context
+	std      types.Importer
+	resolved map[importKey]*build.Package
+	packages map[string]*types.Package
+}
+
+// importKey identifies where an import path was resolved. Within a module an
+// import path always resolves to the same package, so only the module root is
+// needed, rather than the directory of the importing file.
+type importKey struct {
+	path string
+	root string
+}
+
+// NewImporter returns an Importer that imports packages as they would be built
+// for the ScanContext.
+func NewImporter(sc ScanContext) *Importer {
+	return &Importer{
+		fset:     token.NewFileSet(),
+		sc:       sc,
+		ctxt:     sc.buildContext(),
+		std:      importer.Default(),
+		resolved: make(map[importKey]*build.Package),
+		packages: make(map[string]*types.Package),
 	}
+}
 
-	resolveBasicLit := func(node *ast.BasicLit) uint8 {
-		switch state {
-		case ExpectingKey:
-			if node.Kind != token.STRING {
-				handleError(node, errorBadType)
-				return NilState
-			}
+// Import imports the package with the provided path relative to the working
+// directory. Fulfils the types.Importer interface.
+func (imp *Importer) Import(path string) (*types.Package, error) {
+	return imp.ImportFrom(path, ".", 0)
+}
 
-			res := Resource{Key: node.Value[1 : len(node.Value)-1]}
-			resources = append(resources, res)
-			return ExpectingPath
+// ImportFrom imports the package with the provided path, as it would be
+// imported by a file in dir. Fulfils the types.ImporterFrom interface.
+func (imp *Importer) ImportFrom(
+	path, dir string,
+	mode types.ImportMode,
+) (*types.Package, error) {
+	if path == "unsafe" {
+		return types.Unsafe, nil
+	}
 
-		case ExpectingPath:
-			if node.Kind != token.STRING {
-				handleError(node, errorBadType)
-				return NilState
-			}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
 
-			res := &resources[len(resources)-1]
-			res.Path = node.Value[1 : len(node.Value)-1]
-			return NilState
+	// Resolving an import within a module runs the go command, so each import
+	// path is only resolved once per module.
+	key := importKey{path: path, root: moduleRoot(dir)}
+	if build.IsLocalImport(path) {
+		key.root = dir
+	}
+
+	bpkg, ok := imp.resolved[key]
+	if !ok {
+		bpkg, err = imp.sc.importPackage(path, dir)
+		if err != nil {
+			return nil, err
 		}
 
-		return state
+		imp.resolved[key] = bpkg
 	}
 
-	// Walk the AST.
-	ast.Inspect(f, func(node ast.Node) bool {
-		if node == nil {
-			return false
+	// The export data of the standard library is only available for the
+	// host, so it is checked from source when scanning for another target.
+	host := imp.ctxt.GOOS == build.Default.GOOS &&
+		imp.ctxt.GOARCH == build.Default.GOARCH
+
+	if bpkg.Goroot && host {
+		return imp.std.Import(path)
+	}
+
+	if pkg, ok := imp.packages[bpkg.Dir]; ok {
+		if pkg == nil {
+			return nil, fmt.Errorf("import cycle through %s", path)
 		}
 
-		switch n := node.(type) {
-		case *ast.Ident:
-			state = resolveIdent(n)
+		return pkg, nil
+	}
 
-		case *ast.BasicLit:
-			state = resolveBasicLit(n)
+	// Mark the package as being imported, so that a cycle is reported rather
+	// than importing forever.
+	imp.packages[bpkg.Dir] = nil
 
-		default:
-			if state == ExpectingKey || state == ExpectingPath {
-				handleError(node, errorBadType)
-			}
+	files, _ := imp.parseFiles(bpkg.Dir, imp.sc.goFiles(bpkg))
+	pkg := imp.check(bpkg.ImportPath, inPackage(files, bpkg.Name), nil)
+	imp.packages[bpkg.Dir] = pkg
 
-			state = NilState
+	return pkg, nil
+}
+
+// moduleRoot returns the root directory of the module containing dir, or dir
+// itself if it isn't within a module.
+func moduleRoot(dir string) string {
+	for root := dir; ; {
+		if _, err := os.Stat(filepath.Join(root, "go.mod")); err == nil {
+			return root
 		}
 
-		return true
-	})
+		parent := filepath.Dir(root)
+		if parent == root {
+			return dir
+		}
 
-	return resources, errors.SafeReturn()
+		root = parent
+	}
 }
 
-// getResourcesInFile will parse through a file, and identify all calls to
-// Resource(), it will return a slice of Boxes so that Zap and pack these into
-// Go source.
-func getResourcesInFile(fpath string) ([]Resource, error) {
-	var resources []Resource
+// parseFiles parses the named Go files in the directory, leaving out the code
+// generated by zap.
+func (imp *Importer) parseFiles(
+	dir string,
+	names []string,
+) ([]*ast.File, error) {
+	var files []*ast.File
+	var errors aggregateError
 
-	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, fpath, nil, 0)
-	if err != nil {
-		return nil, err
+	for _, name := range names {
+		if isEmbedFile(name) {
+			continue
+		}
+
+		fpath := filepath.Join(dir, name)
+		f, err := parser.ParseFile(imp.fset, fpath, nil, 0)
+		if err != nil {
+			errors.Add(err)
+			continue
+		}
+
+		files = append(files, f)
+	}
+
+	return files, errors.SafeReturn()
+}
+
+// inPackage returns the files that declare the package with the provided name,
+// or all of them if the name is empty. Files excluded by their build
+// constraints, such as programs marked with the ignore tag, and external test
+// files can be for a different package than the rest of the directory.
+func inPackage(files []*ast.File, name string) []*ast.File {
+	if name == "" {
+		return files
+	}
+
+	var matching []*ast.File
+	for _, f := range files {
+		if f.Name.Name == name {
+			matching = append(matching, f)
+		}
 	}
 
-	importName := getZappedImportName(f)
-	if importName == "" || importName == "_" || importName == "." {
-		return resources, nil
+	return matching
+}
+
+// check type checks the files of the package, recording the types and values
+// of expressions in info if it is provided. Type errors are ignored, as the
+// package only needs to be understood well enough to evaluate constants, and
+// files for different targets may redeclare the same identifiers when all
+// files are being scanned. Without info the package is only being imported for
+// its constants, so the bodies of its functions are skipped.
+func (imp *Importer) check(
+	path string,
+	files []*ast.File,
+	info *types.Info,
+) *types.Package {
+	conf := types.Config{
+		Importer:         imp,
+		Sizes:            types.SizesFor("gc", imp.ctxt.GOARCH),
+		Error:            func(error) {},
+		IgnoreFuncBodies: info == nil,
 	}
 
-	return parse(f, fset, importName)
+	checked, _ := conf.Check(path, imp.fset, files, info)
+	return checked
 }
 
 // correctlyPathResources takes a collection of resources that have relative
@@ -301,6 +668,9 @@ func correctlyPathResources(pkgPath string, base []Resource) []Resource {
 		resources = append(resources, Resource{
 			Key:  res.Key,
 			Path: filepath.Join(pkgPath, res.Path),
+			File: res.File,
+			Test: res.Test,
+			Pos:  res.Pos,
 		})
 	}
 
@@ -308,41 +678,176 @@ func correctlyPathResources(pkgPath string, base []Resource) []Resource {
 }
 
 // GetResourcesInPackage will return a slice of Resources that are correctly
-// pathed.
-func GetResourcesInPackage(pkg *build.Package) ([]Resource, error) {
+// pathed, from the files that are part of the package when built for the
+// ScanContext of the Importer, and from its tests. The package is type checked
+// so that constants passed to Resource() can be evaluated, including those
+// declared in the packages it imports.
+func GetResourcesInPackage(
+	pkg *build.Package,
+	imp *Importer,
+) ([]Resource, error) {
 	var resources []Resource
 	var errors aggregateError
 
-	for _, file := range pkg.GoFiles {
-		if file == "zap.embed.go" {
-			continue
+	sc := imp.sc
+
+	files, err := imp.parseFiles(pkg.Dir, sc.goFiles(pkg))
+	if err != nil {
+		errors.Add(err)
+	}
+
+	tests, err := imp.parseFiles(pkg.Dir, sc.testGoFiles(pkg))
+	if err != nil {
+		errors.Add(err)
+	}
+
+	files = inPackage(files, pkg.Name)
+	internal := inPackage(tests, pkg.Name)
+	external := inPackage(tests, pkg.Name+"_test")
+
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+
+	// Tests in the package are compiled along with it, while those in the
+	// external test package import it like any other package would.
+	withTests := append(append([]*ast.File(nil), files...), internal...)
+	imp.check(pkg.ImportPath, withTests, info)
+	imp.check(pkg.ImportPath+"_test", external, info)
+
+	scan := func(files []*ast.File, test bool) {
+		for _, f := range files {
+			importName := getZappedImportName(f)
+			if importName == "" || importName == "_" {
+				continue
+			}
+
+			res, err := parse(f, imp.fset, importName, info)
+			if err != nil {
+				errors.Add(err)
+				continue
+			}
+
+			for i := range res {
+				res[i].Test = test
+			}
+
+			resources = append(resources, correctlyPathResources(pkg.Dir, res)...)
 		}
+	}
 
-		fpath := filepath.Join(pkg.Dir, file)
-		res, err := getResourcesInFile(fpath)
-		if err != nil {
-			errors.Add(err)
-			continue
+	scan(files, false)
+	scan(internal, true)
+	scan(external, true)
+
+	return resources, errors.SafeReturn()
+}
+
+// CheckKeys returns an error describing every call to Resource() with a key
+// that is used elsewhere in the project for a different path, or for the same
+// path with a call to ResourceFile(), as the resources would otherwise
+// overwrite each other. Calls to the same function with the same key and path
+// refer to the same resource, so they are allowed.
+func CheckKeys(resources []Resource) error {
+	var errors aggregateError
+
+	paths := make(map[string]map[string]bool)
+	files := make(map[string]map[bool]bool)
+	for _, res := range resources {
+		if paths[res.Key] == nil {
+			paths[res.Key] = make(map[string]bool)
+			files[res.Key] = make(map[bool]bool)
 		}
 
-		resources = append(resources, correctlyPathResources(pkg.Dir, res)...)
+		paths[res.Key][filepath.Clean(res.Path)] = true
+		files[res.Key][res.File] = true
 	}
 
-	return resources, errors.SafeReturn()
+	var conflicting []Resource
+	for _, res := range resources {
+		if len(paths[res.Key]) > 1 || len(files[res.Key]) > 1 {
+			conflicting = append(conflicting, res)
+		}
+	}
+
+	// Keeping the calls for each key together, in the order they appear in
+	// the source, makes the conflicts easier to follow.
+	sort.SliceStable(conflicting, func(i, j int) bool {
+		a, b := conflicting[i], conflicting[j]
+
+		switch {
+		case a.Key != b.Key:
+			return a.Key < b.Key
+		case a.Pos.Filename != b.Pos.Filename:
+			return a.Pos.Filename < b.Pos.Filename
+		case a.Pos.Line != b.Pos.Line:
+			return a.Pos.Line < b.Pos.Line
+		}
+
+		return a.Pos.Column < b.Pos.Column
+	})
+
+	for _, res := range conflicting {
+		msg := fmt.Sprintf(
+			"key %q refers to %s here, but to a different path elsewhere",
+			res.Key,
+			res.Path,
+		)
+
+		if len(paths[res.Key]) == 1 {
+			msg = fmt.Sprintf(
+				"key %q refers to %s with both Resource() and ResourceFile()",
+				res.Key,
+				res.Path,
+			)
+		}
+
+		errors.Add(positionError(res.Pos, msg))
+	}
+
+	return errors.SafeReturn()
 }
 
 // EmbedDirectories will return a map of directories containg the contents of
-// the files within them.
-func EmbedDirectories(resources []Resource) (map[string]*Directory, error) {
+// the files within them. Resources that are a single file are returned as a
+// Directory holding just that file. The size, mode and modification time of
+// each file is recorded alongside its contents, unless zeroModTimes is set, in
+// which case modification times are left empty so that the output is
+// reproducible.
+func EmbedDirectories(
+	resources []Resource,
+	zeroModTimes bool,
+) (map[string]*Directory, error) {
 	var errors aggregateError
 
 	dirs := make(map[string]*Directory)
 
+	ffn := func(fpath string, info os.FileInfo) (File, error) {
+		bytes, err := ioutil.ReadFile(fpath)
+		if err != nil {
+			return File{}, err
+		}
+
+		embedded := File{
+			Contents: bytes,
+			Size:     int64(len(bytes)),
+			Mode:     info.Mode(),
+			Hash:     fmt.Sprintf("%x", sha256.Sum256(bytes)),
+		}
+
+		if !zeroModTimes {
+			embedded.ModTime = info.ModTime()
+		}
+
+		return embedded, nil
+	}
+
 	var dfn func(string) (*Directory, error)
 	dfn = func(dpath string) (*Directory, error) {
 		var dnfErrors aggregateError
 		dir := Directory{}
-		dir.Files = make(map[string][]byte)
+		dir.Files = make(map[string]File)
 
 		files, err := ioutil.ReadDir(dpath)
 		if err != nil {
@@ -352,13 +857,21 @@ func EmbedDirectories(resources []Resource) (map[string]*Directory, error) {
 		for _, file := range files {
 			// In case someone has version controlled the folder they store
 			// embeddable assets in - stops the tool getting stuck on this
-			// potentially massive file.
-			if file.Name() == ".git" || file.Name() == "zap.embed.go" {
+			// potentially massive file. The zapped library applies the same
+			// rules in development mode, so they must be kept in sync.
+			if file.Name() == ".git" || isEmbedFile(file.Name()) {
 				continue
 			}
 
 			fpath := filepath.Join(dpath, file.Name())
 
+			// The directory may already have been embedded for another
+			// resource that it is nested within, or that is nested within it.
+			if _, exists := dirs[fpath]; exists && file.IsDir() {
+				dir.SubDirs = append(dir.SubDirs, fpath)
+				continue
+			}
+
 			switch file.IsDir() {
 			case true:
 				subdir, err := dfn(fpath)
@@ -370,13 +883,13 @@ func EmbedDirectories(resources []Resource) (map[string]*Directory, error) {
 				dirs[fpath] = subdir
 				dir.SubDirs = append(dir.SubDirs, fpath)
 			case false:
-				bytes, err := ioutil.ReadFile(fpath)
+				embedded, err := ffn(fpath, file)
 				if err != nil {
 					dnfErrors.Add(err)
 					continue
 				}
 
-				dir.Files[file.Name()] = bytes
+				dir.Files[file.Name()] = embedded
 			}
 		}
 
@@ -384,7 +897,38 @@ func EmbedDirectories(resources []Resource) (map[string]*Directory, error) {
 	}
 
 	for _, res := range resources {
-		if _, exists := dirs[res.Path]; exists {
+		if dir, exists := dirs[res.Path]; exists {
+			switch {
+			case dir.Key == "":
+				dir.Key = res.Key
+				dir.Test = res.Test
+			case !res.Test:
+				dir.Test = false
+			}
+
+			continue
+		}
+
+		if res.File {
+			info, err := os.Stat(res.Path)
+			if err != nil {
+				errors.Add(err)
+				continue
+			}
+
+			if info.IsDir() {
+				err := fmt.Errorf("%s: ResourceFile() requires a file", res.Path)
+				errors.Add(err)
+				continue
+			}
+
+			file, err := ffn(res.Path, info)
+			if err != nil {
+				errors.Add(err)
+				continue
+			}
+
+			dirs[res.Path] = &Directory{Key: res.Key, File: &file, Test: res.Test}
 			continue
 		}
 
@@ -395,101 +939,549 @@ func EmbedDirectories(resources []Resource) (map[string]*Directory, error) {
 		}
 
 		dir.Key = res.Key
+		dir.Test = res.Test
 		dirs[res.Path] = dir
 	}
 
+	// A resource nested within one that is used outside of tests is part of
+	// it, so it has to be compiled outside of tests as well.
+	for dpath, dir := range dirs {
+		if dir.Test && nestedInNonTest(dirs, dpath) {
+			dir.Test = false
+		}
+	}
+
 	return dirs, errors.SafeReturn()
 }
 
-// GenerateCode will return a slice of bytes containing the code that should be
-// written so that file contents can be accessed within the binary. The output
-// has been run through the Go formatter.
-func GenerateCode(dirs map[string]*Directory, devMode bool) ([]byte, error) {
+// nestedInNonTest reports if the directory at the path is within a directory
+// that was passed to a call to Resource() from outside of tests.
+func nestedInNonTest(dirs map[string]*Directory, dpath string) bool {
+	for {
+		parent := filepath.Dir(dpath)
+		if parent == dpath {
+			return false
+		}
+
+		if dir, ok := dirs[parent]; ok && dir.Key != "" && !dir.Test {
+			return true
+		}
+
+		dpath = parent
+	}
+}
+
+// EncryptDirectories marks the resources with the provided keys, along with all
+// of their subdirectories, to be encrypted with AES-GCM when the code is
+// generated. The secret must be 16, 24 or 32 bytes long, selecting AES-128,
+// AES-192 or AES-256. It is only held in memory, and is never written into
+// the generated code, so the same secret must be passed to zapped.Unlock at
+// runtime to read the files.
+func EncryptDirectories(
+	dirs map[string]*Directory,
+	keys []string,
+	secret []byte,
+) error {
+	var errors aggregateError
+
+	if _, err := aes.NewCipher(secret); err != nil {
+		return err
+	}
+
+	var mark func(string)
+	mark = func(dpath string) {
+		dir := dirs[dpath]
+		dir.Secret = secret
+
+		for _, subd := range dir.SubDirs {
+			mark(subd)
+		}
+	}
+
+	for _, key := range keys {
+		found := false
+
+		for dpath, dir := range dirs {
+			if dir.Key == key {
+				mark(dpath)
+				found = true
+			}
+		}
+
+		if !found {
+			errors.Add(fmt.Errorf("no resource with the key %s to encrypt", key))
+		}
+	}
+
+	return errors.SafeReturn()
+}
+
+// encrypt seals the contents with AES-GCM using the secret, returning the nonce
+// followed by the encrypted contents. The nonce is derived from the secret and
+// the contents, rather than being random, so that the output is the same every
+// time the code is generated. This reveals when two files have the same
+// contents, but nothing more. A separate key is derived from the secret for
+// the nonce so that the AES key isn't used for two purposes.
+func encrypt(secret, contents []byte) ([]byte, error) {
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	mac := hmac.New(sha256.New, nonceKey(secret))
+	mac.Write(contents)
+	nonce := mac.Sum(nil)[:gcm.NonceSize()]
+
+	return gcm.Seal(nonce, nonce, contents, nil), nil
+}
+
+// nonceKey returns the key used to derive the nonces for contents encrypted
+// with the secret.
+func nonceKey(secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("nonce"))
+	return mac.Sum(nil)
+}
+
+// compress returns the contents compressed with gzip, so that they take up less
+// space in the generated code. If compressing the contents doesn't make them
+// any smaller, nil is returned and they should be stored as they are.
+func compress(contents []byte) ([]byte, error) {
 	var buf bytes.Buffer
-	var sortedDirs []string
+
+	// The gzip header is left empty so that the output is the same every time
+	// the code is generated.
+	gz, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := gz.Write(contents); err != nil {
+		return nil, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	if buf.Len() >= len(contents) {
+		return nil, nil
+	}
+
+	return buf.Bytes(), nil
+}
+
+// stringLiteral returns a Go string literal holding the contents. A raw string
+// literal is used when the contents can be written out as they are, which is
+// the most compact form for text. Otherwise the contents are quoted, escaping
+// only the bytes that need it. The zapped library converts the strings into
+// byte slices when they are needed.
+func stringLiteral(contents []byte) string {
+	raw := utf8.Valid(contents) &&
+		!bytes.ContainsAny(contents, "`\r\x00\uFEFF")
+
+	if raw {
+		return "`" + string(contents) + "`"
+	}
+
+	return strconv.Quote(string(contents))
+}
+
+// IndexFile is the name of the file GenerateCode writes the code that isn't
+// specific to any resource to.
+const IndexFile = "zap.embed.go"
+
+// TestTag is the build tag that the code for resources only used by tests is
+// constrained to, so that it is left out of other builds.
+const TestTag = "zaptest"
+
+// isEmbedFile reports whether the file with the provided name is one that
+// GenerateCode writes. Such files are skipped when embedding directories and
+// finding resources, and the zapped library skips them in development mode,
+// so these rules must be kept in sync.
+func isEmbedFile(name string) bool {
+	return name == IndexFile ||
+		(strings.HasPrefix(name, "zap.embed.") && strings.HasSuffix(name, ".go"))
+}
+
+// embedFileNames returns the name of the file the code for each resource key
+// is written to, of the form zap.embed.<key>.go. Keys are lower cased and
+// anything other than letters and digits is replaced with a dash, so that the
+// names are safe on every filesystem and never look like test files or files
+// for a specific GOOS or GOARCH to the go tool. Keys that would end up with
+// the same name have a hash of the key added to tell them apart.
+func embedFileNames(keys []string) map[string]string {
+	names := make(map[string]string)
+	counts := make(map[string]int)
+
+	clean := func(key string) string {
+		return strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+				return r
+			case r >= 'A' && r <= 'Z':
+				return r - 'A' + 'a'
+			}
+
+			return '-'
+		}, key)
+	}
+
+	for _, key := range keys {
+		counts[clean(key)]++
+	}
+
+	for _, key := range keys {
+		name := clean(key)
+		if counts[name] > 1 || strings.Trim(name, "-") == "" {
+			sum := sha1.Sum([]byte(key))
+			name = fmt.Sprintf("%s-%x", name, sum[:4])
+		}
+
+		names[key] = fmt.Sprintf("zap.embed.%s.go", name)
+	}
+
+	return names
+}
+
+// resourceKey returns the key of the resource that the directory at the path
+// belongs to, which is the key of the closest directory containing it, or the
+// directory itself, that was passed to a call to Resource().
+func resourceKey(dirs map[string]*Directory, dpath string) string {
+	for {
+		if dir, ok := dirs[dpath]; ok && dir.Key != "" {
+			return dir.Key
+		}
+
+		parent := filepath.Dir(dpath)
+		if parent == dpath {
+			return ""
+		}
+
+		dpath = parent
+	}
+}
+
+// GenerateCode will return the code that should be written so that file
+// contents can be accessed within the binary, as a map from the name of each
+// file to its contents. The code for each resource is written to its own file,
+// see embedFileNames, with IndexFile holding everything else, so that changing
+// the files in one resource only changes the code for that resource. Resources
+// only used by tests are written to zap.embed.test.<key>.go instead, and are
+// constrained to TestTag. The output has been run through the Go formatter.
+func GenerateCode(
+	dirs map[string]*Directory,
+	devMode bool,
+) (map[string][]byte, error) {
+	return generateCode(dirs, devMode, stringLiteral)
+}
+
+// generateCode generates the code for GenerateCode, writing out the contents
+// of files with the provided function, so that other ways of writing them can
+// be compared against it.
+func generateCode(
+	dirs map[string]*Directory,
+	devMode bool,
+	literal func([]byte) string,
+) (map[string][]byte, error) {
 	var errors aggregateError
+	var keys []string
+
 	hashMap := make(map[string]string)
+	resourceDirs := make(map[string][]string)
+	nonTest := make(map[string]bool)
+
+	for dpath, dir := range dirs {
+		hashMap[dpath] = fmt.Sprintf("_%x", sha1.Sum([]byte(dpath)))
 
-	for dpath := range dirs {
-		sortedDirs = append(sortedDirs, dpath)
+		if dir.Key != "" && !dir.Test {
+			nonTest[dir.Key] = true
+		}
+
+		key := resourceKey(dirs, dpath)
+		if _, ok := resourceDirs[key]; !ok && key != "" {
+			keys = append(keys, key)
+		}
+
+		resourceDirs[key] = append(resourceDirs[key], dpath)
 	}
 
-	sort.Slice(sortedDirs, func(i, j int) bool {
-		ic := strings.Count(sortedDirs[i], string(os.PathSeparator))
-		jc := strings.Count(sortedDirs[j], string(os.PathSeparator))
-		return ic > jc
-	})
+	sort.Strings(keys)
+
+	type TmplFile struct {
+		Contents         []byte
+		Compressed       []byte
+		Sealed           []byte
+		SealedCompressed bool
+		Size             int64
+		Mode             string
+		ModTime          int64
+		Hash             string
+	}
 
 	type TmplDir struct {
 		Name  string
 		Hash  string
 		Key   string
-		Files map[string][]byte
+		File  *TmplFile
+		Files map[string]TmplFile
 		Dirs  map[string]string
 	}
 
 	type TmplData struct {
 		DevMode bool
+		Tag     string
 		Dirs    []TmplDir
 	}
 
-	tmplData := TmplData{DevMode: devMode}
+	tmplFile := func(file File, secret []byte) (TmplFile, error) {
+		tf := TmplFile{
+			Size: file.Size,
+			Mode: fmt.Sprintf("%#o", uint32(file.Mode.Perm())),
+		}
+
+		// The hash of the plaintext would let anyone confirm a guess of the
+		// contents of an encrypted file, so it is only recorded for files
+		// that aren't encrypted. GCM already detects if sealed contents have
+		// been altered.
+		if secret == nil {
+			tf.Hash = file.Hash
+		}
 
-	for _, path := range sortedDirs {
+		compressed, err := compress(file.Contents)
+
+		switch {
+		case err != nil:
+			return TmplFile{}, err
+		case secret != nil:
+			// Encrypted contents can't be compressed, so the contents are
+			// compressed first when that makes them smaller.
+			contents := file.Contents
+			if compressed != nil {
+				contents = compressed
+			}
+
+			tf.Sealed, err = encrypt(secret, contents)
+			if err != nil {
+				return TmplFile{}, err
+			}
+
+			tf.SealedCompressed = compressed != nil
+		case compressed != nil:
+			tf.Compressed = compressed
+		default:
+			tf.Contents = file.Contents
+		}
+
+		// The runtime stores modification times as nanoseconds since the Unix
+		// epoch, so that the generated code doesn't need to import the time
+		// package. Zero is used to indicate no time was recorded.
+		if !file.ModTime.IsZero() {
+			tf.ModTime = file.ModTime.UnixNano()
+		}
+
+		return tf, nil
+	}
+
+	tmplDir := func(path string) (TmplDir, error) {
 		dir := dirs[path]
-		hash := fmt.Sprintf("_%x", sha1.Sum([]byte(path)))
-		hashMap[path] = hash
 
 		dt := TmplDir{
 			Name:  path,
-			Hash:  hash,
+			Hash:  hashMap[path],
 			Key:   dir.Key,
-			Files: dir.Files,
+			Files: make(map[string]TmplFile),
 			Dirs:  make(map[string]string),
 		}
 
-		for _, subd := range dir.SubDirs {
-			tpath, err := filepath.Rel(path, subd)
+		if dir.File != nil {
+			tf, err := tmplFile(*dir.File, dir.Secret)
 			if err != nil {
-				errors.Add(err)
-				continue
+				return TmplDir{}, err
 			}
 
-			dt.Dirs[tpath] = hashMap[subd]
+			dt.File = &tf
 		}
 
-		tmplData.Dirs = append(tmplData.Dirs, dt)
+		for name, file := range dir.Files {
+			tf, err := tmplFile(file, dir.Secret)
+			if err != nil {
+				return TmplDir{}, err
+			}
+
+			dt.Files[name] = tf
+		}
+
+		for _, subd := range dir.SubDirs {
+			dt.Dirs[filepath.Base(subd)] = hashMap[subd]
+		}
+
+		return dt, nil
 	}
 
-	tmpl := template.Must(template.New("tmpl").Parse(strings.TrimSpace(`
+	funcs := template.FuncMap{"literal": literal}
+	tmpl := template.Must(template.New("tmpl").Funcs(funcs).Parse(`
+{{- define "file" }}
+		{{- if .Sealed }}
+		encrypted: &encryptedContents{
+			sealed: {{ literal .Sealed }},
+			compressed: {{ .SealedCompressed }},
+		},
+		{{- else if .Compressed }}
+		compressed: &compressedContents{
+			gzip: {{ literal .Compressed }},
+		},
+		{{- else }}
+		contents: {{ literal .Contents }},
+		{{- end }}
+		size: {{ .Size }},
+		mode: {{ .Mode }},
+		modTime: {{ .ModTime }},
+		hash: {{ printf "%q" .Hash }},
+{{- end }}
+
+{{- define "index" -}}
 package zapped
-	
+
 func init() {
 	developmentMode = {{ printf "%t" .DevMode }}
+}
+{{ range $dir := .Dirs }}
+{{ template "dir" $dir }}
+{{ end }}
+{{- end }}
+
+{{- define "resource" -}}
+{{- if .Tag -}}
+//go:build {{ .Tag }}
+// +build {{ .Tag }}
 
+{{ end -}}
+package zapped
 {{ range $dir := .Dirs }}
-	// {{ $dir.Name }}
-	{{ $dir.Hash }} := Directory{
-		directories: make(map[string]*Directory),
-		files: make(map[string]File),
-	}
-	{{ range $path, $hash := $dir.Dirs }}
-	{{ $dir.Hash }}.directories["{{ $path }}"] = &{{ $hash }}
-	{{- end -}}
-	{{ range $name, $body := $dir.Files }}
-	{{ $dir.Hash }}.files["{{ $name }}"] = File{ {{ printf "%#v" $body }} }
+{{ template "dir" $dir }}
+{{ end }}
+func init() {
+	{{- range $dir := .Dirs }}
+	{{- if and $dir.Key $dir.File }}
+	fileResources[{{ printf "%q" $dir.Key }}] = &{{ $dir.Hash }}
+	{{- else if $dir.Key }}
+	resources[{{ printf "%q" $dir.Key }}] = &{{ $dir.Hash }}
+	{{- end }}
 	{{- end }}
-	{{ if ne $dir.Key "" }} resources["{{ $dir.Key }}"] = &{{ $dir.Hash }} {{ end }}
-{{ end -}}
 }
-`)))
+{{- end }}
 
-	tmpl.Execute(&buf, tmplData)
+{{- define "dir" -}}
+// {{ .Name }}
+{{- if .File }}
+var {{ .Hash }} = File{
+	{{- template "file" .File }}
+}
+{{- else }}
+var {{ .Hash }} = Directory{
+	directories: map[string]*Directory{
+		{{- range $name, $hash := .Dirs }}
+		{{ printf "%q" $name }}: &{{ $hash }},
+		{{- end }}
+	},
+	files: map[string]File{
+		{{- range $name, $file := .Files }}
+		{{ printf "%q" $name }}: {
+			{{- template "file" $file }}
+		},
+		{{- end }}
+	},
+}
+{{- end }}
+{{- end }}
+`))
+
+	code := make(map[string][]byte)
+	names := embedFileNames(keys)
+
+	render := func(name, tmplName string, data TmplData) {
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, tmplName, data); err != nil {
+			errors.Add(err)
+			return
+		}
+
+		formatted, err := format.Source(buf.Bytes())
+		if err != nil {
+			errors.Add(err)
+			return
+		}
+
+		code[name] = formatted
+	}
+
+	// Directories that aren't part of any resource are written to the index
+	// file, along with the mode the library is running in.
+	for _, key := range append([]string{""}, keys...) {
+		paths := resourceDirs[key]
+		sort.Strings(paths)
+
+		data := TmplData{DevMode: devMode}
+		for _, path := range paths {
+			dt, err := tmplDir(path)
+			if err != nil {
+				errors.Add(err)
+				continue
+			}
+
+			data.Dirs = append(data.Dirs, dt)
+		}
 
-	formatted, err := format.Source(buf.Bytes())
+		if key == "" {
+			render(IndexFile, "index", data)
+			continue
+		}
+
+		name := names[key]
+		if !nonTest[key] {
+			name = "zap.embed.test." + strings.TrimPrefix(name, "zap.embed.")
+			data.Tag = TestTag
+		}
+
+		render(name, "resource", data)
+	}
+
+	return code, errors.SafeReturn()
+}
+
+// WriteCode writes the code returned by GenerateCode into the directory, and
+// removes any files GenerateCode wrote previously that are no longer needed,
+// such as those for resources that have since been removed.
+func WriteCode(dir string, code map[string][]byte) error {
+	var errors aggregateError
+
+	existing, err := ioutil.ReadDir(dir)
 	if err != nil {
-		errors.Add(err)
+		return err
+	}
+
+	for _, file := range existing {
+		if _, ok := code[file.Name()]; ok || !isEmbedFile(file.Name()) {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, file.Name())); err != nil {
+			errors.Add(err)
+		}
+	}
+
+	for name, contents := range code {
+		err := ioutil.WriteFile(filepath.Join(dir, name), contents, 0666)
+		if err != nil {
+			errors.Add(err)
+		}
 	}
 
-	return formatted, errors.SafeReturn()
+	return errors.SafeReturn()
 }
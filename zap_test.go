@@ -17,14 +17,28 @@
 package zap
 
 import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"go/ast"
 	"go/build"
 	"go/parser"
 	"go/token"
+	"go/types"
+	"io/ioutil"
+	"math/rand"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"reflect"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 )
@@ -66,6 +80,26 @@ func parseGo(t *testing.T, src string) (*ast.File, *token.FileSet) {
 	return file, fset
 }
 
+// checkGo will type check the provided file, returning the information needed
+// to evaluate the constants in it. Type errors are ignored, as the packages it
+// imports may not exist.
+func checkGo(t *testing.T, f *ast.File, fset *token.FileSet) *types.Info {
+	t.Helper()
+
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+
+	conf := types.Config{
+		Importer: NewImporter(ScanContext{}),
+		Error:    func(error) {},
+	}
+
+	conf.Check("test", fset, []*ast.File{f}, info)
+	return info
+}
+
 // assertResourceSliceMatch will assert that the actual slice of resources
 // matches the expected one.
 func assertResourceSliceMatch(t *testing.T, exp, act []Resource) {
@@ -80,7 +114,8 @@ func assertResourceSliceMatch(t *testing.T, exp, act []Resource) {
 		expected := exp[i]
 		actual := act[i]
 
-		if expected.Key != actual.Key || expected.Path != actual.Path {
+		if expected.Key != actual.Key || expected.Path != actual.Path ||
+			expected.File != actual.File || expected.Test != actual.Test {
 			t.Error("Slices did not match")
 			return
 		}
@@ -96,7 +131,7 @@ func assertInt(t *testing.T, expected, actual int) {
 
 // getWd will return the current working directory the test resides in, if an
 // error occurs as the working directory is fetched, the test is failed.
-func getWd(t *testing.T) string {
+func getWd(t testing.TB) string {
 	t.Helper()
 
 	wd, err := os.Getwd()
@@ -107,6 +142,27 @@ func getWd(t *testing.T) string {
 	return wd
 }
 
+// writeModule will write the provided files into a temporary directory,
+// returning the path of the directory. The names of the files are slash
+// separated paths relative to the directory.
+func writeModule(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	root := t.TempDir()
+	for name, contents := range files {
+		fpath := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fpath), 0755); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if err := ioutil.WriteFile(fpath, []byte(contents), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	return root
+}
+
 // endIfFailed will check if the test has been marked as failing, and terminate
 // it early if it has.
 func endIfFailed(t *testing.T) {
@@ -116,7 +172,7 @@ func endIfFailed(t *testing.T) {
 }
 
 func TestGetPackagesInProject(t *testing.T) {
-	pkgs, err := GetPackagesInProject(".")
+	pkgs, err := GetPackagesInProject(".", ScanContext{})
 	if err != nil {
 		t.Fatal(err.Error())
 	}
@@ -126,7 +182,7 @@ func TestGetPackagesInProject(t *testing.T) {
 		names = append(names, pkg.Name)
 	}
 
-	assertStringSliceMatch(t, []string{"zap", "main", "zapped"}, names)
+	assertStringSliceMatch(t, []string{"zap", "main", "zapped", "migrations"}, names)
 }
 
 func TestGetZappedImportName(t *testing.T) {
@@ -166,12 +222,8 @@ func TestGenerateParserError(t *testing.T) {
 
 	f, fset := parseGo(t, code)
 
-	err := generateParseError(fset, f.Pos(), errorUnknownCall)
-	expected := "main.go:1:1: expected Resource() but was something else"
-	assertString(t, expected, err.Error())
-
-	err = generateParseError(fset, f.Pos(), errorBadType)
-	expected = "main.go:1:1: calls to Resource() require string literals"
+	err := generateParseError(fset, f.Pos(), errorBadType)
+	expected := "main.go:1:1: calls to Resource() require constant strings"
 	assertString(t, expected, err.Error())
 }
 
@@ -197,6 +249,23 @@ import "zapped"
 func main() {
 	zapped.Resource("A", "scripts/")
 	zapped.Resource("B", "sql/")
+}`,
+		},
+		{
+			name: "WithResourceFile",
+			err:  "",
+			expectedResources: []Resource{
+				{Key: "A", Path: "scripts/"},
+				{Key: "B", Path: "LICENSE", File: true},
+			},
+			code: `
+package test
+
+import "zapped"
+
+func main() {
+	zapped.Resource("A", "scripts/")
+	zapped.ResourceFile("B", "LICENSE")
 }`,
 		},
 		{
@@ -218,7 +287,7 @@ func main() {
 		},
 		{
 			name: "WithIdentKeyInsteadOfLiteral",
-			err:  "main.go:8:18: calls to Resource() require string literals",
+			err:  "main.go:8:18: calls to Resource() require constant strings",
 			expectedResources: []Resource{
 				{Key: "B", Path: "sql/"},
 			},
@@ -236,7 +305,7 @@ func main() {
 		},
 		{
 			name: "WithIdentPathInsteadOfLiteral",
-			err:  "main.go:8:23: calls to Resource() require string literals",
+			err:  "main.go:8:23: calls to Resource() require constant strings",
 			expectedResources: []Resource{
 				{Key: "A"},
 				{Key: "B", Path: "sql/"},
@@ -253,6 +322,158 @@ func main() {
 	zapped.Resource("B", "sql/")
 }`,
 		},
+		{
+			name: "WithOtherReferences",
+			err:  "",
+			expectedResources: []Resource{
+				{Key: "A", Path: "scripts/"},
+			},
+			code: `
+package test
+
+import "zapped"
+
+var resource = zapped.Resource
+
+func load(dir *zapped.Directory) zapped.File {
+	file, _ := dir.Open("index.html")
+	return file
+}
+
+func main() {
+	dir, _ := zapped.Resource("A", "scripts/")
+	load(dir)
+}`,
+		},
+		{
+			name: "WithShadowedIdentifier",
+			err:  "",
+			expectedResources: []Resource{
+				{Key: "B", Path: "sql/"},
+			},
+			code: `
+package test
+
+import "zapped"
+
+type loader struct{}
+
+func (loader) Resource(key, path string) {}
+
+func main() {
+	zapped.Resource("B", "sql/")
+
+	zapped := loader{}
+	zapped.Resource("A", "scripts/")
+}`,
+		},
+		{
+			name: "WithConstants",
+			err:  "",
+			expectedResources: []Resource{
+				{Key: "A", Path: "scripts/"},
+				{Key: "B", Path: "sql/"},
+			},
+			code: `
+package test
+
+import "zapped"
+
+const (
+	keyA = "A"
+	dir  = "scripts/"
+)
+
+func main() {
+	const keyB = "B"
+
+	zapped.Resource(keyA, dir)
+	zapped.Resource(keyB, ("sql/"))
+}`,
+		},
+		{
+			name: "WithConcatenation",
+			err:  "",
+			expectedResources: []Resource{
+				{Key: "A1", Path: "scripts/js"},
+				{Key: "B", Path: "static/" + runtime.GOOS},
+			},
+			code: `
+package test
+
+import (
+	"runtime"
+	"zapped"
+)
+
+const prefix = "A"
+
+func main() {
+	zapped.Resource(prefix+"1", "scripts/"+"js")
+	zapped.Resource("B", "static/"+runtime.GOOS)
+}`,
+		},
+		{
+			name: "WithNonStringConstant",
+			err:  "main.go:6:18: calls to Resource() require constant strings",
+			expectedResources: []Resource{
+				{Key: "B", Path: "sql/"},
+			},
+			code: `
+package test
+
+import "zapped"
+
+func main() {
+	zapped.Resource(1, "scripts/")
+	zapped.Resource("B", "sql/")
+}`,
+		},
+		{
+			name: "WithEscapes",
+			err:  "",
+			expectedResources: []Resource{
+				{Key: "A\tB", Path: `assets\web`},
+				{Key: `"quoted"`, Path: "C:\\assets"},
+			},
+			code: `
+package test
+
+import "zapped"
+
+func main() {
+	zapped.Resource("A\tB", "assets\\web")
+	zapped.Resource("\"quoted\"", "\x43:\\assets")
+}`,
+		},
+		{
+			name: "WithUnicode",
+			err:  "",
+			expectedResources: []Resource{
+				{Key: "é", Path: "ressources/é"},
+				{Key: "日本", Path: "日本"},
+			},
+			code: `
+package test
+
+import "zapped"
+
+func main() {
+	zapped.Resource("\u00e9", "ressources/é")
+	zapped.Resource("日本", "\xe6\x97\xa5\u672c")
+}`,
+		},
+		{
+			name: "WithRawStrings",
+			err:  "",
+			expectedResources: []Resource{
+				{Key: "A", Path: `assets\web`},
+				{Key: `B\n`, Path: "sql/\"raw\""},
+			},
+			code: "\npackage test\n\nimport \"zapped\"\n\nfunc main() {\n" +
+				"\tzapped.Resource(`A`, `assets\\web`)\n" +
+				"\tzapped.Resource(`B\\n`, `sql/\"raw\"`)\n}",
+		},
 	}
 
 	for _, test := range tests {
@@ -261,7 +482,7 @@ func main() {
 			f, fset := parseGo(s, src)
 
 			imp := getZappedImportName(f)
-			resources, err := parse(f, fset, imp)
+			resources, err := parse(f, fset, imp, checkGo(s, f, fset))
 
 			if test.err == "" && err != nil {
 				msg := "an error occured and isn't expected\n%s"
@@ -277,11 +498,48 @@ func main() {
 	}
 }
 
+func TestConstantString(t *testing.T) {
+	tests := []struct {
+		expr     string
+		expected string
+		ok       bool
+	}{
+		{`"assets"`, "assets", true},
+		{`"assets\\web"`, `assets\web`, true},
+		{`"\"quoted\""`, `"quoted"`, true},
+		{`"\u00e9\t"`, "é\t", true},
+		{"`assets\\web`", `assets\web`, true},
+		{"`\\u00e9`", `\u00e9`, true},
+		{`'a'`, "", false},
+		{`1`, "", false},
+		{`"a" + "b"`, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.expr, func(s *testing.T) {
+			expr, err := parser.ParseExpr(test.expr)
+			if err != nil {
+				s.Fatal(err.Error())
+			}
+
+			// Without type information only literals can be evaluated, which
+			// is what is being tested here.
+			value, ok := constantString(nil, expr)
+			if ok != test.ok {
+				s.Fatalf("expected ok to be %t", test.ok)
+			}
+
+			assertString(s, test.expected, value)
+		})
+	}
+}
+
 func TestCorrectlyPathResources(t *testing.T) {
 	resources := []Resource{
 		{Key: "KEY1", Path: "scripts/"},
 		{Key: "KEY2", Path: "sql/"},
 		{Key: "KEY3", Path: "html/"},
+		{Key: "KEY4", Path: "LICENSE", File: true},
 	}
 
 	fixed := correctlyPathResources("project/", resources)
@@ -290,6 +548,7 @@ func TestCorrectlyPathResources(t *testing.T) {
 		{Key: "KEY1", Path: "project/scripts"},
 		{Key: "KEY2", Path: "project/sql"},
 		{Key: "KEY3", Path: "project/html"},
+		{Key: "KEY4", Path: "project/LICENSE", File: true},
 	}
 
 	assertResourceSliceMatch(t, expected, fixed)
@@ -301,7 +560,7 @@ func TestGetResourcesInPackage(t *testing.T) {
 		t.Fatalf("an error occured: %s", err.Error())
 	}
 
-	resources, err := GetResourcesInPackage(pkg)
+	resources, err := GetResourcesInPackage(pkg, NewImporter(ScanContext{}))
 	if err != nil {
 		t.Fatalf("an error occured: %s", err.Error())
 	}
@@ -313,174 +572,1348 @@ func TestGetResourcesInPackage(t *testing.T) {
 	assertResourceSliceMatch(t, expected, resources)
 }
 
-func TestEmbedDirectories(t *testing.T) {
-	// Because this test interacts with the filesystem, these ensure that the
-	// test will use the correct files and have the correct paths, no matter
-	// which machine it is being run from.
-	wd := getWd(t)
-	rel := func(path string) string {
-		return filepath.Join(wd, path)
-	}
+func TestGetResourcesInModule(t *testing.T) {
+	// The package is type checked against the others in a module of its own,
+	// which is how it would be in a project using zap.
+	root := writeModule(t, map[string]string{
+		"go.mod": "module example\n\ngo 1.16\n",
+		"zapped/zapped.go": `package zapped
 
-	dirs, err := EmbedDirectories([]Resource{{"A", rel("testdata")}})
-	if err != nil {
-		t.Fatalf("an error occured: %s", err.Error())
-	}
+func Resource(key, path string) (interface{}, error) { return nil, nil }
+`,
+		"paths/paths.go": `package paths
 
-	// If it didn't manage to get all the directories, fail it now to avoid
-	// having memory panics.
-	assertInt(t, 3, len(dirs))
-	endIfFailed(t)
+const Assets = "assets" + "/"
+`,
+		"dot.go": `package main
 
-	type file struct {
-		name string
-		body string
-	}
+import . "example/zapped"
 
-	tests := []struct {
-		name    string
-		path    string
-		subdirs []string
-		files   []file
-	}{
-		{
-			name:    "testdata",
-			path:    rel("testdata"),
-			subdirs: []string{rel("testdata/accounting")},
-			files: []file{
-				{
-					name: "testdata.go",
-					body: `
-package testdata
+func init() {
+	Resource("DOT", "dot")
+}
+`,
+		"main.go": `package main
 
 import (
-	"zap/zapped"
+	"example/paths"
+	"example/zapped"
 )
 
+const key = "KEY"
+
 func main() {
-	zapped.Resource("KEY", "PATH/")
+	zapped.Resource(key, paths.Assets)
+	zapped.Resource(key+"2", paths.Assets+"css")
 }
 `,
-				},
-			},
-		},
-		{
-			name:    "accounting",
-			path:    rel("testdata/accounting"),
-			subdirs: []string{rel("testdata/accounting/clients")},
-			files: []file{
-				{
-					name: "data.txt",
-					body: `
-AccountName: jordanockoljic
-Balance: 143.50`,
-				},
-			},
-		},
-		{
-			name:    "clients",
-			path:    rel("testdata/accounting/clients"),
-			subdirs: []string{},
-			files: []file{
-				{
-					name: "a.txt",
-					body: `
-AccountName: A
-Balance: 243512.34`,
-				},
-				{
-					name: "b.txt",
-					body: `
-AccountName: B
-Balance: 748362.34`,
-				},
-			},
-		},
+	})
+
+	pkg, err := build.ImportDir(root, 0)
+	if err != nil {
+		t.Fatalf("an error occured: %s", err.Error())
 	}
 
-	for _, test := range tests {
-		t.Run(test.name, func(s *testing.T) {
-			dir := dirs[test.path]
+	resources, err := GetResourcesInPackage(pkg, NewImporter(ScanContext{}))
+	if err != nil {
+		t.Fatalf("an error occured: %s", err.Error())
+	}
 
-			// Check if the number of files embedded, matches the number of
-			// files expected to be embedded.
-			assertInt(s, len(test.files), len(dir.Files))
-			endIfFailed(s)
+	expected := []Resource{
+		{Key: "DOT", Path: filepath.Join(root, "dot")},
+		{Key: "KEY", Path: filepath.Join(root, "assets")},
+		{Key: "KEY2", Path: filepath.Join(root, "assets", "css")},
+	}
 
-			// Check that the subdirectories that have been embedded are the
-			// ones we expect.
-			assertStringSliceMatch(t, test.subdirs, dir.SubDirs)
+	assertResourceSliceMatch(t, expected, resources)
+}
 
-			// Check that the files are what we expect.
-			for _, file := range test.files {
-				expected := strings.TrimLeft(file.body, "\n")
+func TestGetResourcesInPackageTests(t *testing.T) {
+	root := writeModule(t, map[string]string{
+		"go.mod": "module example\n\ngo 1.16\n",
+		"zapped/zapped.go": `package zapped
 
-				var actual string
-				if emb, ok := dir.Files[file.name]; ok {
-					actual = string(emb)
-				}
+func Resource(key, path string) (interface{}, error) { return nil, nil }
+`,
+		"paths/paths.go": `package paths
+
+import "example/zapped"
+
+const Fixtures = "fixtures"
+
+func init() {
+	zapped.Resource("ASSETS", "assets")
+}
+`,
+		"paths/paths_test.go": `package paths
+
+import "example/zapped"
+
+func init() {
+	zapped.Resource("INTERNAL", Fixtures+"/internal")
+}
+`,
+		"paths/paths_external_test.go": `package paths_test
+
+import (
+	"example/paths"
+	"example/zapped"
+)
+
+func init() {
+	zapped.Resource("EXTERNAL", paths.Fixtures+"/external")
+}
+`,
+	})
+
+	dir := filepath.Join(root, "paths")
+
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		t.Fatalf("an error occured: %s", err.Error())
+	}
+
+	resources, err := GetResourcesInPackage(pkg, NewImporter(ScanContext{}))
+	if err != nil {
+		t.Fatalf("an error occured: %s", err.Error())
+	}
+
+	expected := []Resource{
+		{Key: "ASSETS", Path: filepath.Join(dir, "assets")},
+		{
+			Key:  "INTERNAL",
+			Path: filepath.Join(dir, "fixtures", "internal"),
+			Test: true,
+		},
+		{
+			Key:  "EXTERNAL",
+			Path: filepath.Join(dir, "fixtures", "external"),
+			Test: true,
+		},
+	}
+
+	assertResourceSliceMatch(t, expected, resources)
+}
+
+func TestImporterReusesPackages(t *testing.T) {
+	root := writeModule(t, map[string]string{
+		"go.mod": "module example\n\ngo 1.16\n",
+		"zapped/zapped.go": `package zapped
+
+func Resource(key, path string) (interface{}, error) { return nil, nil }
+`,
+		"a/a.go": `package a
+
+import "example/zapped"
+
+func init() {
+	zapped.Resource("A", "a")
+}
+`,
+		"b/b.go": `package b
+
+import "example/zapped"
+
+func init() {
+	zapped.Resource("B", "b")
+}
+`,
+	})
+
+	imp := NewImporter(ScanContext{})
+	zappedDir := filepath.Join(root, "zapped")
+
+	var checked *types.Package
+	for _, name := range []string{"a", "b"} {
+		pkg, err := build.ImportDir(filepath.Join(root, name), 0)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if _, err := GetResourcesInPackage(pkg, imp); err != nil {
+			t.Fatal(err.Error())
+		}
+
+		if checked == nil {
+			checked = imp.packages[zappedDir]
+		}
+	}
+
+	if checked == nil || imp.packages[zappedDir] != checked {
+		t.Error("Expected the shared package to only be checked once")
+	}
+
+	// Both packages are in the same module, so the import should only have
+	// been resolved once, for the module rather than for each directory.
+	key := importKey{path: "example/zapped", root: root}
+	if bpkg, ok := imp.resolved[key]; !ok || bpkg.Dir != zappedDir {
+		t.Errorf("Expected the import to be resolved once for %s", root)
+	}
+
+	if len(imp.resolved) != 1 {
+		t.Errorf("Expected 1 resolved import got %d", len(imp.resolved))
+	}
+}
+
+func TestScanContext(t *testing.T) {
+	root := writeModule(t, map[string]string{
+		"go.mod": "module example\n\ngo 1.16\n",
+		"zapped/zapped.go": `package zapped
+
+func Resource(key, path string) (interface{}, error) { return nil, nil }
+`,
+		"main.go": `package main
+
+import (
+	"example/zapped"
+	"runtime"
+)
+
+func main() {
+	zapped.Resource("OS", "os/"+runtime.GOOS)
+}
+`,
+		"linux.go": `//go:build linux
+// +build linux
+
+package main
+
+import "example/zapped"
+
+func init() {
+	zapped.Resource("LINUX", "linux")
+}
+`,
+		"windows.go": `//go:build windows
+// +build windows
+
+package main
+
+import "example/zapped"
+
+func init() {
+	zapped.Resource("WINDOWS", "windows")
+}
+`,
+		"pro.go": `//go:build pro
+// +build pro
+
+package main
+
+import "example/zapped"
+
+func init() {
+	zapped.Resource("PRO", "pro")
+}
+`,
+		"gen.go": `//go:build ignore
+// +build ignore
+
+package gen
+
+import "example/zapped"
+
+func init() {
+	zapped.Resource("GEN", "gen")
+}
+`,
+		"only/only_windows.go": `package only
+
+import "example/zapped"
+
+func init() {
+	zapped.Resource("ONLY", "only")
+}
+`,
+	})
+
+	tests := []struct {
+		name     string
+		sc       ScanContext
+		expected []string
+	}{
+		{"Linux", ScanContext{GOOS: "linux"}, []string{"LINUX", "OS"}},
+		{
+			"Windows",
+			ScanContext{GOOS: "windows", GOARCH: "amd64"},
+			[]string{"OS", "WINDOWS", "ONLY"},
+		},
+		{
+			"Tags",
+			ScanContext{GOOS: "linux", Tags: []string{"pro"}},
+			[]string{"LINUX", "OS", "PRO"},
+		},
+		{
+			"AllFiles",
+			ScanContext{GOOS: "linux", AllFiles: true},
+			[]string{"LINUX", "OS", "PRO", "WINDOWS", "ONLY"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			pkgs, err := GetPackagesInProject(root, test.sc)
+			if err != nil {
+				s.Fatal(err.Error())
+			}
+
+			var keys []string
+			imp := NewImporter(test.sc)
+			for _, pkg := range pkgs {
+				resources, err := GetResourcesInPackage(pkg, imp)
+				if err != nil {
+					s.Fatal(err.Error())
+				}
+
+				for _, res := range resources {
+					keys = append(keys, res.Key)
+
+					// The constants of the standard library have to match the
+					// target being scanned for.
+					if res.Key == "OS" {
+						goos := test.sc.GOOS
+						assertString(s, filepath.Join(root, "os", goos), res.Path)
+					}
+				}
+			}
+
+			assertStringSliceMatch(s, test.expected, keys)
+		})
+	}
+}
+
+func TestCheckKeys(t *testing.T) {
+	code := `
+package test
+
+import "zapped"
+
+func main() {
+	zapped.Resource("A", "scripts/")
+	zapped.Resource("B", "sql")
+	zapped.Resource("A", "html/")
+	zapped.Resource("B", "sql/")
+}`
+
+	f, fset := parseGo(t, strings.TrimSpace(code))
+	resources, err := parse(f, fset, "zapped", checkGo(t, f, fset))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Calls from other packages are checked alongside them.
+	other := token.Position{Filename: "other.go", Line: 3, Column: 2}
+	resources = append(resources, Resource{Key: "A", Path: "js", Pos: other})
+
+	expected := strings.Join([]string{
+		`main.go:6:2: key "A" refers to scripts/ here, but to a different path elsewhere`,
+		`main.go:8:2: key "A" refers to html/ here, but to a different path elsewhere`,
+		`other.go:3:2: key "A" refers to js here, but to a different path elsewhere`,
+	}, "\n")
+
+	err = CheckKeys(resources)
+	if err == nil {
+		t.Fatal("Expected an error when keys are used for different paths")
+	}
+
+	assertString(t, expected, err.Error())
+
+	// The same key can be used for the same path as many times as needed.
+	err = CheckKeys([]Resource{resources[1], resources[3]})
+	if err != nil {
+		t.Errorf("an error occured and isn't expected\n%s", err.Error())
+	}
+
+	// But not for both a directory and a single file, as only one of them
+	// could be embedded.
+	file := resources[3]
+	file.File = true
+	file.Pos = other
+
+	expected = strings.Join([]string{
+		`main.go:7:2: key "B" refers to sql with both Resource() and ResourceFile()`,
+		`other.go:3:2: key "B" refers to sql/ with both Resource() and ResourceFile()`,
+	}, "\n")
+
+	err = CheckKeys([]Resource{resources[1], file})
+	if err == nil {
+		t.Fatal("Expected an error when a key is used for a directory and a file")
+	}
+
+	assertString(t, expected, err.Error())
+}
+
+func TestEmbedDirectories(t *testing.T) {
+	// Because this test interacts with the filesystem, these ensure that the
+	// test will use the correct files and have the correct paths, no matter
+	// which machine it is being run from.
+	wd := getWd(t)
+	rel := func(path string) string {
+		return filepath.Join(wd, path)
+	}
+
+	dirs, err := EmbedDirectories([]Resource{{Key: "A", Path: rel("testdata")}}, false)
+	if err != nil {
+		t.Fatalf("an error occured: %s", err.Error())
+	}
+
+	// If it didn't manage to get all the directories, fail it now to avoid
+	// having memory panics.
+	assertInt(t, 3, len(dirs))
+	endIfFailed(t)
+
+	type file struct {
+		name string
+		body string
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		subdirs []string
+		files   []file
+	}{
+		{
+			name:    "testdata",
+			path:    rel("testdata"),
+			subdirs: []string{rel("testdata/accounting")},
+			files: []file{
+				{
+					name: "testdata.go",
+					body: `
+package testdata
+
+import (
+	"zap/zapped"
+)
+
+func main() {
+	zapped.Resource("KEY", "PATH/")
+}
+`,
+				},
+			},
+		},
+		{
+			name:    "accounting",
+			path:    rel("testdata/accounting"),
+			subdirs: []string{rel("testdata/accounting/clients")},
+			files: []file{
+				{
+					name: "data.txt",
+					body: `
+AccountName: jordanockoljic
+Balance: 143.50`,
+				},
+			},
+		},
+		{
+			name:    "clients",
+			path:    rel("testdata/accounting/clients"),
+			subdirs: []string{},
+			files: []file{
+				{
+					name: "a.txt",
+					body: `
+AccountName: A
+Balance: 243512.34`,
+				},
+				{
+					name: "b.txt",
+					body: `
+AccountName: B
+Balance: 748362.34`,
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			dir := dirs[test.path]
+
+			// Check if the number of files embedded, matches the number of
+			// files expected to be embedded.
+			assertInt(s, len(test.files), len(dir.Files))
+			endIfFailed(s)
+
+			// Check that the subdirectories that have been embedded are the
+			// ones we expect.
+			assertStringSliceMatch(t, test.subdirs, dir.SubDirs)
+
+			// Check that the files are what we expect.
+			for _, file := range test.files {
+				expected := strings.TrimLeft(file.body, "\n")
+
+				var actual string
+				emb, ok := dir.Files[file.name]
+				if ok {
+					actual = string(emb.Contents)
+				}
+
+				assertString(s, expected, actual)
+
+				// Check that the metadata was recorded from the filesystem.
+				info, err := os.Stat(filepath.Join(test.path, file.name))
+				if err != nil {
+					s.Fatal(err.Error())
+				}
+
+				assertInt(s, int(info.Size()), int(emb.Size))
+				assertString(s, info.Mode().String(), emb.Mode.String())
+
+				if !info.ModTime().Equal(emb.ModTime) {
+					s.Errorf("expected %v got %v", info.ModTime(), emb.ModTime)
+				}
+
+				hash := fmt.Sprintf("%x", sha256.Sum256([]byte(expected)))
+				assertString(s, hash, emb.Hash)
+			}
+		})
+	}
+}
+
+func TestEmbedDirectoriesZeroModTimes(t *testing.T) {
+	path := filepath.Join(getWd(t), "testdata", "accounting")
+
+	dirs, err := EmbedDirectories([]Resource{{Key: "A", Path: path}}, true)
+	if err != nil {
+		t.Fatalf("an error occured: %s", err.Error())
+	}
+
+	for dpath, dir := range dirs {
+		for name, file := range dir.Files {
+			if !file.ModTime.IsZero() {
+				t.Errorf("%s in %s has modification time", name, dpath)
+			}
+		}
+	}
+}
+
+func TestGenerateCode(t *testing.T) {
+	expIndex := `
+package zapped
+
+func init() {
+	developmentMode = false
+}
+`
+
+	expResource := `
+package zapped
+
+// %PROJECTPATH%/testdata
+var %TESTDATA% = Directory{
+	directories: map[string]*Directory{
+		"accounting": &%ACCOUNTING%,
+	},
+	files: map[string]File{
+		"testdata.go": {
+			contents: %RAW%package testdata
+
+import (
+	"zap/zapped"
+)
+
+func main() {
+	zapped.Resource("KEY", "PATH/")
+}
+%RAW%,
+			size:    93,
+			mode:    0644,
+			modTime: 0,
+			hash:    "554614df715221550f081895d65576a8a6dec3c2c876555576bb16614f3cdfaf",
+		},
+	},
+}
+
+// %PROJECTPATH%/testdata/accounting
+var %ACCOUNTING% = Directory{
+	directories: map[string]*Directory{
+		"clients": &%CLIENTS%,
+	},
+	files: map[string]File{
+		"data.txt": {
+			contents: %RAW%AccountName: jordanockoljic
+Balance: 143.50%RAW%,
+			size:    43,
+			mode:    0644,
+			modTime: 0,
+			hash:    "041f9b7095058f65d73780fff472e37d451251743f1d784510eaa86c62a4b232",
+		},
+	},
+}
+
+// %PROJECTPATH%/testdata/accounting/clients
+var %CLIENTS% = Directory{
+	directories: map[string]*Directory{},
+	files: map[string]File{
+		"a.txt": {
+			contents: %RAW%AccountName: A
+Balance: 243512.34%RAW%,
+			size:    33,
+			mode:    0644,
+			modTime: 0,
+			hash:    "26bce8132bd38bea7007d58d201cf269a493e33e03cf9104a984fa4dbf5b1afb",
+		},
+		"b.txt": {
+			contents: %RAW%AccountName: B
+Balance: 748362.34%RAW%,
+			size:    33,
+			mode:    0644,
+			modTime: 0,
+			hash:    "220d50c8fccf27dc49b4953d79aa453b0b8558843c95bb222d45772d22acc44a",
+		},
+	},
+}
+
+func init() {
+	resources["F"] = &%TESTDATA%
+}
+`
+
+	// The names of the generated variables are derived from the absolute paths
+	// of the directories, so they depend on where the project is located. The
+	// expected output can't contain backquotes itself, so %RAW% stands in for
+	// them.
+	wd := getWd(t)
+	hash := func(path string) string {
+		return fmt.Sprintf("_%x", sha1.Sum([]byte(filepath.Join(wd, path))))
+	}
+
+	replacer := strings.NewReplacer(
+		"%PROJECTPATH%", wd,
+		"%CLIENTS%", hash("testdata/accounting/clients"),
+		"%ACCOUNTING%", hash("testdata/accounting"),
+		"%TESTDATA%", hash("testdata"),
+		"%RAW%", "`",
+	)
+
+	expected := map[string]string{
+		IndexFile:        strings.TrimLeft(expIndex, "\n"),
+		"zap.embed.f.go": replacer.Replace(strings.TrimLeft(expResource, "\n")),
+	}
+
+	resources := []Resource{
+		{Key: "F", Path: filepath.Join(wd, "testdata")},
+	}
+
+	dirs, err := EmbedDirectories(resources, true)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// File modes depend on the umask of the checkout, so they are normalised.
+	for _, dir := range dirs {
+		for name, file := range dir.Files {
+			file.Mode = 0644
+			dir.Files[name] = file
+		}
+	}
+
+	code, err := GenerateCode(dirs, false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertInt(t, len(expected), len(code))
+
+	for name, exp := range expected {
+		assertString(t, exp, string(code[name]))
+	}
+}
+
+func TestCompress(t *testing.T) {
+	small := []byte("AccountName: A\nBalance: 243512.34")
+
+	compressed, err := compress(small)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if compressed != nil {
+		t.Error("Expected small contents not to be compressed")
+	}
+
+	large := bytes.Repeat(small, 64)
+
+	compressed, err = compress(large)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if compressed == nil || len(compressed) >= len(large) {
+		t.Fatal("Expected large contents to be compressed")
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	contents, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertString(t, string(large), string(contents))
+
+	again, err := compress(large)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if !bytes.Equal(compressed, again) {
+		t.Error("Expected compressing the same contents to be deterministic")
+	}
+}
+
+func TestGenerateCodeCompressed(t *testing.T) {
+	dirs := map[string]*Directory{
+		"assets": {
+			Key: "A",
+			Files: map[string]File{
+				"large.txt": {
+					Contents: bytes.Repeat([]byte("Balance: 143.50\n"), 64),
+					Size:     1024,
+					Mode:     0644,
+				},
+				"small.txt": {
+					Contents: []byte("Balance: 143.50\n"),
+					Size:     16,
+					Mode:     0644,
+				},
+			},
+		},
+	}
+
+	code, err := GenerateCode(dirs, false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
 
-				assertString(s, expected, actual)
+	src := string(code["zap.embed.a.go"])
+	large := src[strings.Index(src, `"large.txt"`):strings.Index(src, `"small.txt"`)]
+	small := src[strings.Index(src, `"small.txt"`):]
+
+	if !strings.Contains(large, "compressed: &compressedContents{") {
+		t.Error("Expected large.txt to be stored compressed")
+	}
+
+	if strings.Contains(small, "compressed:") ||
+		!strings.Contains(small, "contents:") {
+		t.Error("Expected small.txt to be stored as it is")
+	}
+}
+
+func TestEmbedDirectoriesResourceFile(t *testing.T) {
+	path := filepath.Join(getWd(t), "testdata", "accounting")
+	resources := []Resource{
+		{Key: "A", Path: filepath.Join(path, "data.txt"), File: true},
+	}
+
+	dirs, err := EmbedDirectories(resources, false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertInt(t, 1, len(dirs))
+
+	dir, ok := dirs[filepath.Join(path, "data.txt")]
+	if !ok || dir.File == nil {
+		t.Fatal("Expected data.txt to be embedded as a file")
+	}
+
+	assertString(t, "A", dir.Key)
+	assertInt(t, 0, len(dir.Files))
+	assertString(t, "AccountName: jordanockoljic\nBalance: 143.50",
+		string(dir.File.Contents))
+
+	resources = []Resource{{Key: "A", Path: path, File: true}}
+
+	_, err = EmbedDirectories(resources, false)
+	if err == nil {
+		t.Error("Expected an error when a file resource is a directory")
+	}
+}
+
+func TestGenerateCodeResourceFile(t *testing.T) {
+	dirs := map[string]*Directory{
+		"LICENSE": {
+			Key: "L",
+			File: &File{
+				Contents: []byte("MIT\r\n"),
+				Size:     5,
+				Mode:     0644,
+				Hash:     "HASH",
+			},
+		},
+	}
+
+	code, err := GenerateCode(dirs, false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := `package zapped
+
+// LICENSE
+var %LICENSE% = File{
+	contents: "MIT\r\n",
+	size:     5,
+	mode:     0644,
+	modTime:  0,
+	hash:     "HASH",
+}
+
+func init() {
+	fileResources["L"] = &%LICENSE%
+}
+`
+
+	hash := fmt.Sprintf("_%x", sha1.Sum([]byte("LICENSE")))
+	expected = strings.Replace(expected, "%LICENSE%", hash, -1)
+
+	assertString(t, expected, string(code["zap.embed.l.go"]))
+}
+
+func TestEmbedDirectoriesTest(t *testing.T) {
+	path := filepath.Join(getWd(t), "testdata", "accounting")
+	clients := filepath.Join(path, "clients")
+	data := filepath.Join(path, "data.txt")
+
+	tests := []struct {
+		name      string
+		resources []Resource
+		expected  map[string]bool
+	}{
+		{
+			name: "OnlyTests",
+			resources: []Resource{
+				{Key: "A", Path: path, Test: true},
+				{Key: "C", Path: clients, Test: true},
+			},
+			expected: map[string]bool{path: true, clients: true},
+		},
+		{
+			name: "NestedInNonTest",
+			resources: []Resource{
+				{Key: "C", Path: clients, Test: true},
+				{Key: "A", Path: path},
+				{Key: "D", Path: data, File: true, Test: true},
+			},
+			expected: map[string]bool{path: false, clients: false, data: false},
+		},
+		{
+			name: "ContainingNonTest",
+			resources: []Resource{
+				{Key: "A", Path: path, Test: true},
+				{Key: "C", Path: clients},
+			},
+			expected: map[string]bool{path: true, clients: false},
+		},
+		{
+			name: "AlsoUsedOutsideTests",
+			resources: []Resource{
+				{Key: "A", Path: path, Test: true},
+				{Key: "A", Path: path},
+			},
+			expected: map[string]bool{path: false},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			dirs, err := EmbedDirectories(test.resources, false)
+			if err != nil {
+				s.Fatal(err.Error())
+			}
+
+			for dpath, expected := range test.expected {
+				if dirs[dpath].Test != expected {
+					s.Errorf("expected Test of %s to be %t", dpath, expected)
+				}
 			}
 		})
 	}
 }
 
-func TestGenerateCode(t *testing.T) {
-	expTmpl := `
+func TestGenerateCodeTest(t *testing.T) {
+	dirs := map[string]*Directory{
+		"fixtures": {
+			Key:   "F",
+			Files: map[string]File{},
+			Test:  true,
+		},
+		"static": {
+			Key:   "S",
+			Files: map[string]File{},
+		},
+	}
+
+	code, err := GenerateCode(dirs, false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertInt(t, 3, len(code))
+
+	expected := `//go:build zaptest
+// +build zaptest
+
 package zapped
 
+// fixtures
+var %FIXTURES% = Directory{
+	directories: map[string]*Directory{},
+	files:       map[string]File{},
+}
+
 func init() {
-	developmentMode = false
+	resources["F"] = &%FIXTURES%
+}
+`
 
-	// %PROJECTPATH%/testdata/accounting/clients
-	_7c0f43becbec3fcc411fb2eb4cf6781c8938c80c := Directory{
-		directories: make(map[string]*Directory),
-		files:       make(map[string]File),
+	hash := fmt.Sprintf("_%x", sha1.Sum([]byte("fixtures")))
+	expected = strings.Replace(expected, "%FIXTURES%", hash, -1)
+
+	assertString(t, expected, string(code["zap.embed.test.f.go"]))
+
+	if !bytes.HasPrefix(code["zap.embed.s.go"], []byte("package zapped")) {
+		t.Error("Expected resources used outside of tests to be unconstrained")
 	}
+}
+
+func TestEncryptDirectories(t *testing.T) {
+	path := filepath.Join(getWd(t), "testdata", "accounting")
+	secret := bytes.Repeat([]byte{0x42}, 32)
 
-	_7c0f43becbec3fcc411fb2eb4cf6781c8938c80c.files["a.txt"] = File{[]byte{0x41, 0x63, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x4e, 0x61, 0x6d, 0x65, 0x3a, 0x20, 0x41, 0xa, 0x42, 0x61, 0x6c, 0x61, 0x6e, 0x63, 0x65, 0x3a, 0x20, 0x32, 0x34, 0x33, 0x35, 0x31, 0x32, 0x2e, 0x33, 0x34}}
-	_7c0f43becbec3fcc411fb2eb4cf6781c8938c80c.files["b.txt"] = File{[]byte{0x41, 0x63, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x4e, 0x61, 0x6d, 0x65, 0x3a, 0x20, 0x42, 0xa, 0x42, 0x61, 0x6c, 0x61, 0x6e, 0x63, 0x65, 0x3a, 0x20, 0x37, 0x34, 0x38, 0x33, 0x36, 0x32, 0x2e, 0x33, 0x34}}
+	resources := []Resource{{Key: "A", Path: path}}
+
+	dirs, err := EmbedDirectories(resources, true)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
 
-	// %PROJECTPATH%/testdata/accounting
-	_fdcf5ee62ffce988f4735ffa040bff452a5d3e46 := Directory{
-		directories: make(map[string]*Directory),
-		files:       make(map[string]File),
+	err = EncryptDirectories(dirs, []string{"A"}, secret)
+	if err != nil {
+		t.Fatal(err.Error())
 	}
 
-	_fdcf5ee62ffce988f4735ffa040bff452a5d3e46.directories["clients"] = &_7c0f43becbec3fcc411fb2eb4cf6781c8938c80c
-	_fdcf5ee62ffce988f4735ffa040bff452a5d3e46.files["data.txt"] = File{[]byte{0x41, 0x63, 0x63, 0x6f, 0x75, 0x6e, 0x74, 0x4e, 0x61, 0x6d, 0x65, 0x3a, 0x20, 0x6a, 0x6f, 0x72, 0x64, 0x61, 0x6e, 0x6f, 0x63, 0x6b, 0x6f, 0x6c, 0x6a, 0x69, 0x63, 0xa, 0x42, 0x61, 0x6c, 0x61, 0x6e, 0x63, 0x65, 0x3a, 0x20, 0x31, 0x34, 0x33, 0x2e, 0x35, 0x30}}
+	for dpath, dir := range dirs {
+		if !bytes.Equal(secret, dir.Secret) {
+			t.Errorf("Expected %s to be encrypted", dpath)
+		}
+	}
 
-	// %PROJECTPATH%/testdata
-	_4d446d3a99d94af0c61e8be1147dc81ff7df9175 := Directory{
-		directories: make(map[string]*Directory),
-		files:       make(map[string]File),
+	err = EncryptDirectories(dirs, []string{"B"}, secret)
+	if err == nil {
+		t.Error("Expected an error for an unknown key")
 	}
 
-	_4d446d3a99d94af0c61e8be1147dc81ff7df9175.directories["accounting"] = &_fdcf5ee62ffce988f4735ffa040bff452a5d3e46
-	_4d446d3a99d94af0c61e8be1147dc81ff7df9175.files["testdata.go"] = File{[]byte{0x70, 0x61, 0x63, 0x6b, 0x61, 0x67, 0x65, 0x20, 0x74, 0x65, 0x73, 0x74, 0x64, 0x61, 0x74, 0x61, 0xa, 0xa, 0x69, 0x6d, 0x70, 0x6f, 0x72, 0x74, 0x20, 0x28, 0xa, 0x9, 0x22, 0x7a, 0x61, 0x70, 0x2f, 0x7a, 0x61, 0x70, 0x70, 0x65, 0x64, 0x22, 0xa, 0x29, 0xa, 0xa, 0x66, 0x75, 0x6e, 0x63, 0x20, 0x6d, 0x61, 0x69, 0x6e, 0x28, 0x29, 0x20, 0x7b, 0xa, 0x9, 0x7a, 0x61, 0x70, 0x70, 0x65, 0x64, 0x2e, 0x52, 0x65, 0x73, 0x6f, 0x75, 0x72, 0x63, 0x65, 0x28, 0x22, 0x4b, 0x45, 0x59, 0x22, 0x2c, 0x20, 0x22, 0x50, 0x41, 0x54, 0x48, 0x2f, 0x22, 0x29, 0xa, 0x7d, 0xa}}
-	resources["F"] = &_4d446d3a99d94af0c61e8be1147dc81ff7df9175
+	err = EncryptDirectories(dirs, []string{"A"}, secret[:10])
+	if err == nil {
+		t.Error("Expected an error for an invalid secret")
+	}
 }
-`
 
-	wd := getWd(t)
-	expected := strings.ReplaceAll(
-		strings.TrimLeft(expTmpl, "\n"),
-		"%PROJECTPATH%", wd)
+func TestGenerateCodeEncrypted(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x42}, 32)
+	contents := []byte("AccountName: jordanockoljic\nBalance: 143.50")
+	hash := fmt.Sprintf("%x", sha256.Sum256(contents))
 
-	resources := []Resource{
-		{Key: "F", Path: filepath.Join(wd, "testdata")},
+	dirs := map[string]*Directory{
+		"accounting": {
+			Key: "A",
+			Files: map[string]File{
+				"data.txt": {Contents: contents, Size: 43, Mode: 0644, Hash: hash},
+			},
+			Secret: secret,
+		},
 	}
 
-	dirs, err := EmbedDirectories(resources)
+	code, err := GenerateCode(dirs, false)
 	if err != nil {
 		t.Fatal(err.Error())
 	}
 
-	code, err := GenerateCode(dirs, false)
+	src := string(code["zap.embed.a.go"])
+	if !strings.Contains(src, "encrypted: &encryptedContents{") ||
+		strings.Contains(src, "contents:") {
+		t.Errorf("Expected data.txt to be encrypted:\n%s", src)
+	}
+
+	if strings.Contains(src, hash) || !strings.Contains(src, `hash:    "",`) {
+		t.Errorf("Expected the hash of data.txt not to be recorded:\n%s", src)
+	}
+
+	again, err := GenerateCode(dirs, false)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertString(t, src, string(again["zap.embed.a.go"]))
+
+	sealed, err := encrypt(secret, contents)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// The nonce must not be derived using the AES key itself.
+	size := gcm.NonceSize()
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(contents)
+
+	if bytes.Equal(sealed[:size], mac.Sum(nil)[:size]) {
+		t.Error("Expected the nonce to be derived with a separate key")
+	}
+
+	opened, err := gcm.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertString(t, string(contents), string(opened))
+}
+
+func TestStringLiteral(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		raw      bool
+	}{
+		{"Text", "AccountName: A\nBalance: 243512.34", true},
+		{"Unicode", "Café\tété", true},
+		{"Empty", "", true},
+		{"Backquote", "`zap`", false},
+		{"CarriageReturn", "A\r\nB", false},
+		{"Nul", "A\x00B", false},
+		{"ByteOrderMark", "\uFEFFA", false},
+		{"InvalidUTF8", "\xff\xfe\xfd", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(s *testing.T) {
+			literal := stringLiteral([]byte(test.contents))
+
+			if test.raw != strings.HasPrefix(literal, "`") {
+				s.Errorf("Expected raw to be %t for %s", test.raw, literal)
+			}
+
+			expr, err := parser.ParseExpr(literal)
+			if err != nil {
+				s.Fatal(err.Error())
+			}
+
+			lit, ok := expr.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				s.Fatalf("Expected a string literal got %s", literal)
+			}
+
+			value, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				s.Fatal(err.Error())
+			}
+
+			assertString(s, test.contents, value)
+		})
+	}
+}
+
+// benchmarkDirs returns a resource for benchmarking code generation with. It
+// mixes text, which is compressed, with random data, which isn't, to roughly
+// match a directory of web assets.
+func benchmarkDirs() map[string]*Directory {
+	random := rand.New(rand.NewSource(1))
+	files := make(map[string]File)
+
+	for i := 0; i < 8; i++ {
+		var text bytes.Buffer
+		for text.Len() < 64<<10 {
+			fmt.Fprintf(&text, "AccountName: %d\nBalance: %d.%02d\n",
+				random.Int(), random.Intn(1000000), random.Intn(100))
+		}
+
+		binary := make([]byte, 64<<10)
+		random.Read(binary)
+
+		name := fmt.Sprintf("%d", i)
+		files[name+".txt"] = File{Contents: text.Bytes(), Mode: 0644}
+		files[name+".bin"] = File{Contents: binary, Mode: 0644}
+	}
+
+	return map[string]*Directory{"assets": {Key: "A", Files: files}}
+}
+
+// byteSliceLiteral writes out contents the way GenerateCode used to, with one
+// hexadecimal literal per byte, so that it can be compared against.
+func byteSliceLiteral(contents []byte) string {
+	return fmt.Sprintf("string(%#v)", contents)
+}
+
+// literals are the ways of writing out the contents of files that are
+// compared by the benchmarks.
+var literals = []struct {
+	name    string
+	literal func([]byte) string
+}{
+	{"StringLiteral", stringLiteral},
+	{"ByteSlice", byteSliceLiteral},
+}
+
+func BenchmarkGenerateCode(b *testing.B) {
+	dirs := benchmarkDirs()
+
+	for _, lit := range literals {
+		b.Run(lit.name, func(s *testing.B) {
+			var code map[string][]byte
+			var err error
+
+			for i := 0; i < s.N; i++ {
+				code, err = generateCode(dirs, false, lit.literal)
+				if err != nil {
+					s.Fatal(err.Error())
+				}
+			}
+
+			size := 0
+			for _, contents := range code {
+				size += len(contents)
+			}
+
+			s.ReportMetric(float64(size), "output-bytes")
+		})
+	}
+}
+
+func BenchmarkCompileGeneratedCode(b *testing.B) {
+	if testing.Short() {
+		b.Skip("compiling generated code is slow")
+	}
+
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		b.Skip("the go tool is not available")
+	}
+
+	// Build a module containing a copy of the zapped library, that the
+	// generated code can be compiled into.
+	root := b.TempDir()
+	library := filepath.Join(root, "zapped")
+
+	if err := os.Mkdir(library, 0755); err != nil {
+		b.Fatal(err.Error())
+	}
+
+	err = ioutil.WriteFile(
+		filepath.Join(root, "go.mod"), []byte("module bench\n\ngo 1.16\n"), 0644)
+
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+
+	sources, err := filepath.Glob(filepath.Join(getWd(b), "zapped", "*.go"))
+	if err != nil {
+		b.Fatal(err.Error())
+	}
+
+	for _, source := range sources {
+		name := filepath.Base(source)
+		if strings.HasSuffix(name, "_test.go") || isEmbedFile(name) {
+			continue
+		}
+
+		contents, err := ioutil.ReadFile(source)
+		if err != nil {
+			b.Fatal(err.Error())
+		}
+
+		err = ioutil.WriteFile(filepath.Join(library, name), contents, 0644)
+		if err != nil {
+			b.Fatal(err.Error())
+		}
+	}
+
+	dirs := benchmarkDirs()
+
+	for _, lit := range literals {
+		b.Run(lit.name, func(s *testing.B) {
+			code, err := generateCode(dirs, false, lit.literal)
+			if err != nil {
+				s.Fatal(err.Error())
+			}
+
+			for i := 0; i < s.N; i++ {
+				s.StopTimer()
+
+				// Changing the code each time stops the go tool from using
+				// the result of the last build.
+				build := fmt.Sprintf("// Build %s %d.\n", lit.name, i)
+				unique := make(map[string][]byte)
+				for name, contents := range code {
+					unique[name] = append([]byte(build), contents...)
+				}
+
+				if err := WriteCode(library, unique); err != nil {
+					s.Fatal(err.Error())
+				}
+
+				s.StartTimer()
+
+				cmd := exec.Command(goTool, "build", "./zapped")
+				cmd.Dir = root
+
+				if out, err := cmd.CombinedOutput(); err != nil {
+					s.Fatalf("%s\n%s", err.Error(), out)
+				}
+			}
+		})
+	}
+}
+
+func TestEmbedFileNames(t *testing.T) {
+	names := embedFileNames([]string{
+		"static",
+		"Templates",
+		"sql/migrations",
+		"fixtures_test",
+		"a b",
+		"a-b",
+		"!!!",
+	})
+
+	// Keys that can't be told apart once cleaned have the first four bytes
+	// of their hash added.
+	hashed := func(name, key string) string {
+		sum := sha1.Sum([]byte(key))
+		return fmt.Sprintf("zap.embed.%s-%x.go", name, sum[:4])
+	}
+
+	expected := map[string]string{
+		"static":         "zap.embed.static.go",
+		"Templates":      "zap.embed.templates.go",
+		"sql/migrations": "zap.embed.sql-migrations.go",
+		"fixtures_test":  "zap.embed.fixtures-test.go",
+		"a b":            hashed("a-b", "a b"),
+		"a-b":            hashed("a-b", "a-b"),
+		"!!!":            hashed("---", "!!!"),
+	}
+
+	for key, name := range expected {
+		assertString(t, name, names[key])
+
+		if !isEmbedFile(names[key]) {
+			t.Errorf("Expected %s to be recognised as generated", names[key])
+		}
+	}
+}
+
+func TestGenerateCodeNestedResources(t *testing.T) {
+	wd := getWd(t)
+	outer := filepath.Join(wd, "testdata")
+	inner := filepath.Join(wd, "testdata", "accounting")
+
+	orders := [][]Resource{
+		{{Key: "OUTER", Path: outer}, {Key: "INNER", Path: inner}},
+		{{Key: "INNER", Path: inner}, {Key: "OUTER", Path: outer}},
+	}
+
+	for _, resources := range orders {
+		dirs, err := EmbedDirectories(resources, true)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		assertInt(t, 3, len(dirs))
+		assertString(t, "OUTER", dirs[outer].Key)
+		assertString(t, "INNER", dirs[inner].Key)
+
+		code, err := GenerateCode(dirs, false)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		assertInt(t, 3, len(code))
+
+		hash := func(path string) string {
+			return fmt.Sprintf("_%x", sha1.Sum([]byte(path)))
+		}
+
+		// Each directory is only written once, in the file for the closest
+		// resource it belongs to.
+		outerCode := string(code["zap.embed.outer.go"])
+		innerCode := string(code["zap.embed.inner.go"])
+		clients := filepath.Join(inner, "clients")
+
+		if !strings.Contains(outerCode, "var "+hash(outer)) ||
+			!strings.Contains(outerCode, "&"+hash(inner)) ||
+			strings.Contains(outerCode, "var "+hash(inner)) {
+			t.Errorf("Unexpected code for OUTER:\n%s", outerCode)
+		}
+
+		if !strings.Contains(innerCode, "var "+hash(inner)) ||
+			!strings.Contains(innerCode, "var "+hash(clients)) ||
+			!strings.Contains(innerCode, `resources["INNER"]`) {
+			t.Errorf("Unexpected code for INNER:\n%s", innerCode)
+		}
+	}
+}
+
+func TestWriteCode(t *testing.T) {
+	dir := t.TempDir()
+
+	existing := map[string]string{
+		"zapped.go":            "package zapped",
+		"zap.embed.go":         "package zapped",
+		"zap.embed.static.go":  "package zapped",
+		"zap.embed.removed.go": "package zapped",
+	}
+
+	for name, contents := range existing {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(contents), 0644)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	code := map[string][]byte{
+		IndexFile:             []byte("package zapped // index"),
+		"zap.embed.static.go": []byte("package zapped // static"),
+	}
+
+	if err := WriteCode(dir, code); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	files, err := ioutil.ReadDir(dir)
 	if err != nil {
 		t.Fatal(err.Error())
 	}
 
-	assertString(t, expected, string(code))
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+
+	expected := []string{"zap.embed.go", "zap.embed.static.go", "zapped.go"}
+	assertStringSliceMatch(t, expected, names)
+
+	for name, contents := range code {
+		written, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		assertString(t, string(contents), string(written))
+	}
 }
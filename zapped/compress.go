@@ -0,0 +1,104 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// As an exception, you may distribute programs that contain code generated
+// with or copied into by this program under terms of your choice.
+
+package zapped
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// compressedContents holds the contents of an embedded file that zap stored
+// compressed with gzip. The contents are decompressed the first time they are
+// needed, and the result is shared by every copy of the File.
+type compressedContents struct {
+	gzip     string
+	once     sync.Once
+	contents []byte
+	err      error
+}
+
+// decompress returns the decompressed contents. Only the first call does any
+// work, and it is safe to call from multiple goroutines at once.
+func (c *compressedContents) decompress() ([]byte, error) {
+	c.once.Do(func() {
+		c.contents, c.err = gunzip(c.gzip)
+	})
+
+	return c.contents, c.err
+}
+
+// gunzip returns the decompressed contents of the gzip compressed data.
+func gunzip(data string) ([]byte, error) {
+	gz, err := gzip.NewReader(strings.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(gz)
+}
+
+// embeddedContents returns the embedded contents of the file, decrypting and
+// decompressing them if they were stored that way. Contents that were stored
+// as they are are copied out of the string zap generated each time, but
+// decrypted or decompressed contents are shared by every copy of the File and
+// must not be modified; use read to get a copy the caller owns.
+func (file *File) embeddedContents() ([]byte, error) {
+	if file.encrypted != nil {
+		return file.encrypted.decrypt()
+	}
+
+	if file.compressed != nil {
+		return file.compressed.decompress()
+	}
+
+	return []byte(file.contents), nil
+}
+
+// embeddedReader returns a reader for the embedded contents of the file.
+// Contents that were stored as they are are read directly from the string zap
+// generated, without being copied.
+func (file *File) embeddedReader() (contentReader, error) {
+	if file.encrypted == nil && file.compressed == nil {
+		return strings.NewReader(file.contents), nil
+	}
+
+	contents, err := file.embeddedContents()
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(contents), nil
+}
+
+// Compressed returns the contents of the file compressed with gzip, exactly as
+// zap stored them, so that they can be served without decompressing them
+// first. Zap only compresses files when doing so makes them smaller, so if the
+// file wasn't stored compressed, is being read from the filesystem, or is
+// encrypted, ok will be false.
+func (file *File) Compressed() (gzip []byte, ok bool) {
+	if file.diskPath != "" || file.compressed == nil {
+		return nil, false
+	}
+
+	return []byte(file.compressed.gzip), true
+}
@@ -0,0 +1,166 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package zapped
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/fs"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// compressedFile returns a File with the provided contents, stored compressed
+// as zap would embed it.
+func compressedFile(t *testing.T, body string) File {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+
+	if _, err := gz.Write([]byte(body)); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := gz.Close(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	file := embeddedFile(body)
+	file.contents = ""
+	file.compressed = &compressedContents{gzip: buf.String()}
+	return file
+}
+
+func TestCompressedFile(t *testing.T) {
+	body := strings.Repeat("AccountName: A\nBalance: 243512.34\n", 64)
+
+	dir := &Directory{
+		directories: make(map[string]*Directory),
+		files: map[string]File{
+			"a.txt": compressedFile(t, body),
+			"b.txt": embeddedFile("AccountName: B\nBalance: 748362.34"),
+		},
+	}
+
+	err := fstest.TestFS(dir, "a.txt", "b.txt")
+	if err != nil {
+		t.Error(err.Error())
+	}
+
+	file, err := dir.File("a.txt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertString(t, body, file.String())
+
+	contents, err := fs.ReadFile(dir, "a.txt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertString(t, body, string(contents))
+
+	compressed, ok := file.Compressed()
+	if !ok {
+		t.Fatal("Expected a.txt to be compressed")
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	contents, err = ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertString(t, body, string(contents))
+
+	file, err = dir.File("b.txt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, ok := file.Compressed(); ok {
+		t.Error("Expected b.txt not to be compressed")
+	}
+}
+
+func TestCompressedFileConcurrentAccess(t *testing.T) {
+	body := strings.Repeat("AccountName: A\nBalance: 243512.34\n", 64)
+	dir := &Directory{
+		files: map[string]File{"a.txt": compressedFile(t, body)},
+	}
+
+	var wg sync.WaitGroup
+	results := make([][]byte, 16)
+
+	for i := range results {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			file, err := dir.File("a.txt")
+			if err != nil {
+				t.Error(err.Error())
+				return
+			}
+
+			results[i] = file.Bytes()
+		}(i)
+	}
+
+	wg.Wait()
+
+	for _, result := range results {
+		assertString(t, body, string(result))
+	}
+
+	file, err := dir.File("a.txt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Every copy of the File shares the decompressed contents, so they should
+	// only have been decompressed once, but each caller should be given its
+	// own copy of them.
+	shared := file.compressed.contents
+	if len(shared) == 0 {
+		t.Fatal("Expected the decompressed contents to be kept")
+	}
+
+	contents := file.Bytes()
+	if &file.compressed.contents[0] != &shared[0] {
+		t.Error("Expected the contents to only be decompressed once")
+	}
+
+	for _, result := range append(results, contents) {
+		if len(result) != 0 && &result[0] == &shared[0] {
+			t.Error("Expected Bytes to return a copy of the contents")
+		}
+	}
+
+	contents[0] = 'X'
+	assertString(t, body, file.String())
+}
@@ -0,0 +1,163 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// As an exception, you may distribute programs that contain code generated
+// with or copied into by this program under terms of your choice.
+
+package zapped
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"sync"
+)
+
+// unlocked holds the cipher that encrypted files are decrypted with, once the
+// key has been provided to Unlock.
+var unlocked struct {
+	sync.RWMutex
+	aead cipher.AEAD
+}
+
+// encryptedContents holds the contents of an embedded file that zap encrypted
+// with AES-GCM, as the nonce followed by the encrypted data. If compressed is
+// set, the contents were compressed with gzip before being encrypted. The
+// contents are decrypted the first time they are needed after Unlock has been
+// called, and the result is shared by every copy of the File.
+type encryptedContents struct {
+	sealed     string
+	compressed bool
+	mu         sync.Mutex
+	contents   []byte
+	done       bool
+}
+
+// open decrypts the sealed contents with the cipher.
+func (e *encryptedContents) open(aead cipher.AEAD) ([]byte, error) {
+	size := aead.NonceSize()
+	if len(e.sealed) < size {
+		return nil, ErrIncorrectKey
+	}
+
+	sealed := []byte(e.sealed)
+
+	contents, err := aead.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return nil, ErrIncorrectKey
+	}
+
+	if e.compressed {
+		return gunzip(string(contents))
+	}
+
+	return contents, nil
+}
+
+// decrypt returns the decrypted contents. Once the contents have been
+// decrypted, later calls return the same result without doing any work. Until
+// then, ErrLocked is returned if Unlock hasn't been called. It is safe to call
+// from multiple goroutines at once.
+func (e *encryptedContents) decrypt() ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.done {
+		return e.contents, nil
+	}
+
+	unlocked.RLock()
+	aead := unlocked.aead
+	unlocked.RUnlock()
+
+	if aead == nil {
+		return nil, ErrLocked
+	}
+
+	contents, err := e.open(aead)
+	if err != nil {
+		return nil, err
+	}
+
+	e.contents = contents
+	e.done = true
+	return contents, nil
+}
+
+// anyEncrypted returns the encrypted contents of any embedded file, or nil if
+// no files were encrypted.
+func anyEncrypted() *encryptedContents {
+	var find func(dir *Directory) *encryptedContents
+	find = func(dir *Directory) *encryptedContents {
+		for _, file := range dir.files {
+			if file.encrypted != nil {
+				return file.encrypted
+			}
+		}
+
+		for _, sub := range dir.directories {
+			if e := find(sub); e != nil {
+				return e
+			}
+		}
+
+		return nil
+	}
+
+	for _, res := range resources {
+		if e := find(res); e != nil {
+			return e
+		}
+	}
+
+	for _, res := range fileResources {
+		if res.encrypted != nil {
+			return res.encrypted
+		}
+	}
+
+	return nil
+}
+
+// Unlock provides the key that zap encrypted resources with, so that their
+// contents can be read. Files are decrypted the first time they are read after
+// Unlock is called, and until then, reading them returns an error wrapping
+// ErrLocked. The key must be the same 16, 24 or 32 bytes that were given to
+// zap. If any files were encrypted, the key is checked against one of them,
+// and ErrIncorrectKey is returned if it doesn't match. In development mode
+// files are read from the filesystem as they are, so no key is needed.
+func Unlock(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	if e := anyEncrypted(); e != nil {
+		if _, err := e.open(aead); err != nil {
+			return err
+		}
+	}
+
+	unlocked.Lock()
+	unlocked.aead = aead
+	unlocked.Unlock()
+
+	return nil
+}
@@ -0,0 +1,175 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package zapped
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// encryptedFile returns a File with the provided contents, encrypted with the
+// key as zap would embed it, optionally compressing the contents first.
+func encryptedFile(t *testing.T, key []byte, body string, compress bool) File {
+	t.Helper()
+
+	contents := []byte(body)
+	if compress {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(contents)
+		gz.Close()
+		contents = buf.Bytes()
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	file := embeddedFile(body)
+	file.contents = ""
+	file.encrypted = &encryptedContents{
+		sealed:     string(gcm.Seal(nonce, nonce, contents, nil)),
+		compressed: compress,
+	}
+
+	return file
+}
+
+// lockAfterTest forgets any key provided to Unlock once the test is finished.
+func lockAfterTest(t *testing.T) {
+	t.Helper()
+
+	t.Cleanup(func() {
+		unlocked.Lock()
+		unlocked.aead = nil
+		unlocked.Unlock()
+	})
+}
+
+func TestUnlock(t *testing.T) {
+	setDevelopmentMode(t, false)
+	lockAfterTest(t)
+
+	key := bytes.Repeat([]byte{0x42}, 32)
+	small := "AccountName: jordanockoljic\nBalance: 143.50"
+	large := strings.Repeat("AccountName: A\nBalance: 243512.34\n", 64)
+
+	setResource(t, "ACCOUNTING", &Directory{
+		directories: make(map[string]*Directory),
+		files: map[string]File{
+			"data.txt":  encryptedFile(t, key, small, false),
+			"large.txt": encryptedFile(t, key, large, true),
+		},
+	})
+
+	dir, err := Resource("ACCOUNTING", "accounting/")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	_, err = dir.ReadFile("data.txt")
+	assertPathError(t, "ACCOUNTING", "data.txt", ErrLocked, err)
+
+	file, err := dir.File("data.txt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if _, ok := file.Compressed(); ok {
+		t.Error("Expected encrypted contents not to be served compressed")
+	}
+
+	// Bytes can't report the error, so Open is used to find out why the
+	// contents couldn't be read.
+	if file.Bytes() != nil {
+		t.Error("Expected no contents while the file is locked")
+	}
+
+	_, err = file.Open()
+	assertPathError(t, "ACCOUNTING", "data.txt", ErrLocked, err)
+
+	err = Unlock([]byte("too short"))
+	if err == nil {
+		t.Error("Expected an error for an invalid key")
+	}
+
+	err = Unlock(bytes.Repeat([]byte{0x24}, 32))
+	if !errors.Is(err, ErrIncorrectKey) {
+		t.Errorf("Expected ErrIncorrectKey got %v", err)
+	}
+
+	_, err = dir.ReadFile("data.txt")
+	assertPathError(t, "ACCOUNTING", "data.txt", ErrLocked, err)
+
+	if err := Unlock(key); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	contents, err := dir.ReadFile("data.txt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertString(t, small, string(contents))
+
+	contents, err = dir.ReadFile("large.txt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertString(t, large, string(contents))
+
+	// The decrypted contents are shared by every copy of the File, so
+	// changing what Bytes returns mustn't change them.
+	contents = file.Bytes()
+	contents[0] = 'X'
+	assertString(t, small, file.String())
+}
+
+func TestUnlockDevelopment(t *testing.T) {
+	setDevelopmentMode(t, true)
+
+	dir, err := Resource("ACCOUNTING", "../testdata/accounting")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	contents, err := dir.ReadFile("data.txt")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := "AccountName: jordanockoljic\nBalance: 143.50"
+	assertString(t, expected, string(contents))
+}
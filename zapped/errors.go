@@ -0,0 +1,129 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// As an exception, you may distribute programs that contain code generated
+// with or copied into by this program under terms of your choice.
+
+package zapped
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// The errors that can be returned by zapped, regardless of whether files are
+// embedded or being read from the filesystem. They are wrapped in a PathError,
+// so should be checked for with errors.Is.
+var (
+	// ErrNotExist is returned when a file or directory cannot be found. It is
+	// the same error as fs.ErrNotExist and os.ErrNotExist.
+	ErrNotExist = fs.ErrNotExist
+
+	// ErrInvalid is returned when a path is not valid, or refers to a
+	// directory where a file was expected, or the other way around. It is the
+	// same error as fs.ErrInvalid.
+	ErrInvalid = fs.ErrInvalid
+
+	// ErrUnknownResource is returned when no resource has been embedded with
+	// the provided key.
+	ErrUnknownResource = errors.New("unknown resource")
+
+	// ErrHashMismatch is returned by Verify when the contents of an embedded
+	// file no longer match the hash zap recorded when embedding it.
+	ErrHashMismatch = errors.New("hash mismatch")
+
+	// ErrLocked is returned when the contents of an encrypted file are read
+	// before the key they were encrypted with has been provided to Unlock.
+	ErrLocked = errors.New("resource is locked")
+
+	// ErrIncorrectKey is returned when the contents of an encrypted file can't
+	// be decrypted with the key provided to Unlock.
+	ErrIncorrectKey = errors.New("incorrect key")
+
+	// errUnknownCaller is returned in development mode when the file calling
+	// Resource() can't be determined, so the path can't be made relative to
+	// it.
+	errUnknownCaller = errors.New("unable to determine calling file")
+)
+
+// PathError records an error, along with the operation, and the key and path
+// of the resource that caused it. The path is slash-separated and relative to
+// the root of the resource.
+type PathError struct {
+	Op   string
+	Key  string
+	Path string
+	Err  error
+}
+
+// Error returns a description of the error, fulfilling the error interface.
+func (e *PathError) Error() string {
+	name := e.Path
+
+	switch {
+	case e.Key != "" && e.Path != "":
+		name = e.Key + ":" + e.Path
+	case e.Key != "":
+		name = e.Key
+	}
+
+	return e.Op + " " + name + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error, so that PathError can be used with
+// errors.Is and errors.As.
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// underlyingError returns the error wrapped by an fs.PathError, such as those
+// returned by the os package, so that the path isn't repeated when it is
+// wrapped in a PathError. Errors from the filesystem indicating that something
+// doesn't exist are replaced with ErrNotExist, so that they are reported the
+// same way as when files are embedded.
+func underlyingError(err error) error {
+	if errors.Is(err, ErrNotExist) {
+		return ErrNotExist
+	}
+
+	var fsErr *fs.PathError
+	if errors.As(err, &fsErr) {
+		return fsErr.Err
+	}
+
+	return err
+}
+
+// pathError returns a PathError for an operation on the named path within the
+// directory.
+func (dir *Directory) pathError(op, name string, err error) error {
+	return &PathError{
+		Op:   op,
+		Key:  dir.key,
+		Path: joinPath(dir.path, name),
+		Err:  underlyingError(err),
+	}
+}
+
+// pathError returns a PathError for an operation on the file.
+func (file *File) pathError(op string, err error) error {
+	return &PathError{
+		Op:   op,
+		Key:  file.key,
+		Path: file.path,
+		Err:  underlyingError(err),
+	}
+}
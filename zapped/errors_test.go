@@ -0,0 +1,90 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package zapped
+
+import (
+	"errors"
+	"os"
+	"testing"
+)
+
+// assertPathError will assert that the error is a PathError with the expected
+// key and path, wrapping the expected error.
+func assertPathError(t *testing.T, key, path string, target, err error) {
+	t.Helper()
+
+	var pathErr *PathError
+	if !errors.As(err, &pathErr) {
+		t.Errorf("expected a PathError, got %v", err)
+		return
+	}
+
+	assertString(t, key, pathErr.Key)
+	assertString(t, path, pathErr.Path)
+
+	if !errors.Is(err, target) {
+		t.Errorf("expected %v to wrap %v", err, target)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	runAccounting(t, func(s *testing.T, dir *Directory, onDisk bool) {
+		dir.key = "ACCOUNTING"
+
+		_, err := dir.File("clients/c.txt")
+		assertPathError(s, "ACCOUNTING", "clients/c.txt", ErrNotExist, err)
+		assertString(s, "open ACCOUNTING:clients/c.txt: file does not exist", err.Error())
+
+		if !errors.Is(err, os.ErrNotExist) {
+			s.Errorf("expected %v to be os.ErrNotExist", err)
+		}
+
+		_, err = dir.Sub("invoices")
+		assertPathError(s, "ACCOUNTING", "invoices", ErrNotExist, err)
+
+		_, err = dir.Open("invoices/a.txt")
+		assertPathError(s, "ACCOUNTING", "invoices/a.txt", ErrNotExist, err)
+
+		_, err = dir.Stat("clients/c.txt")
+		assertPathError(s, "ACCOUNTING", "clients/c.txt", ErrNotExist, err)
+
+		_, err = dir.ReadFile("clients/c.txt")
+		assertPathError(s, "ACCOUNTING", "clients/c.txt", ErrNotExist, err)
+
+		_, err = dir.File("clients")
+		assertPathError(s, "ACCOUNTING", "clients", ErrInvalid, err)
+
+		_, err = dir.File("clients/../data.txt")
+		assertPathError(s, "ACCOUNTING", "clients/../data.txt", ErrInvalid, err)
+
+		clients, err := dir.Sub("clients")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		_, err = clients.File("c.txt")
+		assertPathError(s, "ACCOUNTING", "clients/c.txt", ErrNotExist, err)
+	})
+}
+
+func TestResourceErrors(t *testing.T) {
+	setDevelopmentMode(t, false)
+
+	_, err := Resource("MISSING", "missing/")
+	assertPathError(t, "MISSING", "", ErrUnknownResource, err)
+	assertString(t, "resource MISSING: unknown resource", err.Error())
+}
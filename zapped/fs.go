@@ -0,0 +1,448 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// As an exception, you may distribute programs that contain code generated
+// with or copied into by this program under terms of your choice.
+
+package zapped
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Directory implements the interfaces from io/fs so that it can be handed to
+// anything in the standard library that accepts an fs.FS.
+var (
+	_ fs.FS         = (*Directory)(nil)
+	_ fs.ReadDirFS  = (*Directory)(nil)
+	_ fs.StatFS     = (*Directory)(nil)
+	_ fs.ReadFileFS = (*Directory)(nil)
+)
+
+// fileInfo describes an embedded file or directory. It fulfils both the
+// fs.FileInfo and fs.DirEntry interfaces.
+type fileInfo struct {
+	name    string
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+}
+
+func (fi *fileInfo) Name() string               { return fi.name }
+func (fi *fileInfo) Size() int64                { return fi.size }
+func (fi *fileInfo) Mode() fs.FileMode          { return fi.mode }
+func (fi *fileInfo) ModTime() time.Time         { return fi.modTime }
+func (fi *fileInfo) IsDir() bool                { return fi.mode.IsDir() }
+func (fi *fileInfo) Sys() interface{}           { return nil }
+func (fi *fileInfo) Type() fs.FileMode          { return fi.mode.Type() }
+func (fi *fileInfo) Info() (fs.FileInfo, error) { return fi, nil }
+
+// fileInfo returns the information describing an embedded file, as it was
+// recorded when the file was embedded.
+func (file *File) fileInfo(name string) *fileInfo {
+	info := fileInfo{name: name, size: file.size, mode: file.mode}
+
+	if file.modTime != 0 {
+		info.modTime = time.Unix(0, file.modTime)
+	}
+
+	return &info
+}
+
+// fileInfo returns the information describing an embedded directory.
+func (dir *Directory) fileInfo(name string) *fileInfo {
+	return &fileInfo{name: name, mode: fs.ModeDir | 0555}
+}
+
+// info returns the information describing the directory. If the directory
+// exists on the filesystem, the information is read from there.
+func (dir *Directory) info(name string) (fs.FileInfo, error) {
+	if dir.diskPath != "" {
+		info, err := os.Stat(dir.diskPath)
+
+		if err == nil {
+			return &fileInfo{
+				name:    name,
+				size:    info.Size(),
+				mode:    info.Mode(),
+				modTime: info.ModTime(),
+			}, nil
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) || !dir.embedded() {
+			return nil, err
+		}
+	}
+
+	return dir.fileInfo(name), nil
+}
+
+// entries returns the contents of the directory, sorted by name. Entries on
+// the filesystem take the place of embedded entries with the same name.
+func (dir *Directory) entries() ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	merged := make(map[string]fs.DirEntry)
+
+	for name, sub := range dir.directories {
+		merged[name] = sub.fileInfo(name)
+	}
+
+	for name, file := range dir.files {
+		merged[name] = file.fileInfo(name)
+	}
+
+	if dir.diskPath != "" {
+		disk, err := diskFS{dir.diskPath}.ReadDir(".")
+
+		if err != nil && (!errors.Is(err, fs.ErrNotExist) || !dir.embedded()) {
+			return nil, err
+		}
+
+		for _, entry := range disk {
+			merged[entry.Name()] = entry
+		}
+	}
+
+	for _, entry := range merged {
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+
+	return entries, nil
+}
+
+// validPath reports whether the provided name is a clean, slash-separated path
+// that is relative to a directory. It follows fs.ValidPath, but additionally
+// rejects backslashes so that paths are treated the same on every platform.
+func validPath(name string) bool {
+	return fs.ValidPath(name) && !strings.Contains(name, `\`)
+}
+
+// joinPath joins a slash-separated path onto the path of a directory. Unlike
+// path.Join, the result is not cleaned, so that invalid paths are reported as
+// they were provided.
+func joinPath(dir, name string) string {
+	switch {
+	case dir == "" || dir == ".":
+		return name
+	case name == ".":
+		return dir
+	}
+
+	return dir + "/" + name
+}
+
+// lookup walks the embedded directory tree to find the entry with the provided
+// slash-separated name. At most one of the returned values will be non-nil,
+// and both will be nil if the entry could not be found.
+func (dir *Directory) lookup(name string) (*Directory, *File) {
+	if name == "." {
+		return dir, nil
+	}
+
+	current := dir
+	elems := strings.Split(name, "/")
+
+	for i, elem := range elems {
+		if i == len(elems)-1 {
+			if file, ok := current.files[elem]; ok {
+				return nil, &file
+			}
+		}
+
+		sub, ok := current.directories[elem]
+		if !ok {
+			return nil, nil
+		}
+
+		current = sub
+	}
+
+	return current, nil
+}
+
+// Open opens the named file or directory, fulfilling the fs.FS interface. The
+// name must be a slash-separated path relative to the directory, as described
+// by fs.ValidPath, and may not contain backslashes.
+func (dir *Directory) Open(name string) (fs.File, error) {
+	if !validPath(name) {
+		return nil, dir.pathError("open", name, ErrInvalid)
+	}
+
+	sub, file, err := dir.resolve(name)
+	if err != nil {
+		return nil, dir.pathError("open", name, err)
+	}
+
+	if file != nil {
+		if file.diskPath != "" {
+			f, err := os.Open(file.diskPath)
+			if err != nil {
+				return nil, file.pathError("open", err)
+			}
+
+			return f, nil
+		}
+
+		reader, err := file.embeddedReader()
+		if err != nil {
+			return nil, file.pathError("open", err)
+		}
+
+		return &openFile{
+			contentReader: reader,
+			info:          file.fileInfo(path.Base(file.path)),
+		}, nil
+	}
+
+	info, err := sub.info(path.Base(name))
+	if err != nil {
+		return nil, dir.pathError("open", name, err)
+	}
+
+	entries, err := sub.entries()
+	if err != nil {
+		return nil, dir.pathError("open", name, err)
+	}
+
+	return &openDir{info: info, entries: entries}, nil
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name,
+// fulfilling the fs.ReadDirFS interface.
+func (dir *Directory) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !validPath(name) {
+		return nil, dir.pathError("readdir", name, ErrInvalid)
+	}
+
+	sub, _, err := dir.resolve(name)
+
+	switch {
+	case err != nil:
+		return nil, dir.pathError("readdir", name, err)
+	case sub == nil:
+		err := dir.pathError("readdir", name, ErrInvalid)
+		return nil, err
+	}
+
+	entries, err := sub.entries()
+	if err != nil {
+		return nil, dir.pathError("readdir", name, err)
+	}
+
+	return entries, nil
+}
+
+// Stat returns the information describing the named file or directory,
+// fulfilling the fs.StatFS interface.
+func (dir *Directory) Stat(name string) (fs.FileInfo, error) {
+	if !validPath(name) {
+		return nil, dir.pathError("stat", name, ErrInvalid)
+	}
+
+	sub, file, err := dir.resolve(name)
+	if err != nil {
+		return nil, dir.pathError("stat", name, err)
+	}
+
+	if file != nil {
+		return file.Stat()
+	}
+
+	info, err := sub.info(path.Base(name))
+	if err != nil {
+		return nil, dir.pathError("stat", name, err)
+	}
+
+	return info, nil
+}
+
+// ReadFile returns a copy of the contents of the named file, fulfilling the
+// fs.ReadFileFS interface.
+func (dir *Directory) ReadFile(name string) ([]byte, error) {
+	if !validPath(name) {
+		return nil, dir.pathError("open", name, ErrInvalid)
+	}
+
+	_, file, err := dir.resolve(name)
+
+	switch {
+	case err != nil:
+		return nil, dir.pathError("open", name, err)
+	case file == nil:
+		err := dir.pathError("read", name, ErrInvalid)
+		return nil, err
+	}
+
+	return file.read()
+}
+
+// skipped reports whether an entry with the provided name is skipped by zap
+// when embedding directories, which includes the files zap generates. This
+// must match the rules in EmbedDirectories.
+func skipped(name string) bool {
+	if name == ".git" || name == "zap.embed.go" {
+		return true
+	}
+
+	return strings.HasPrefix(name, "zap.embed.") && strings.HasSuffix(name, ".go")
+}
+
+// skippedPath reports whether any element of the slash-separated path would
+// be skipped by zap when embedding directories.
+func skippedPath(name string) bool {
+	for _, elem := range strings.Split(name, "/") {
+		if skipped(elem) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// diskFS reads files from the filesystem beneath its root, hiding the entries
+// that zap skips when embedding directories, so that development mode behaves
+// the same as embedded mode.
+type diskFS struct {
+	root string
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+func (disk diskFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if skippedPath(name) {
+		err := &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+		return nil, err
+	}
+
+	all, err := fs.ReadDir(os.DirFS(disk.root), name)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []fs.DirEntry
+	for _, entry := range all {
+		if !skipped(entry.Name()) {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// Stat returns the information describing the named file or directory.
+func (disk diskFS) Stat(name string) (fs.FileInfo, error) {
+	if skippedPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return fs.Stat(os.DirFS(disk.root), name)
+}
+
+// contentReader reads the embedded contents of a file. It can be read from,
+// seeked and read at arbitrary offsets.
+type contentReader interface {
+	io.Reader
+	io.Seeker
+	io.ReaderAt
+}
+
+// openFile is an embedded file that has been opened through the fs.FS
+// interface. It can be read from, seeked and read at arbitrary offsets.
+type openFile struct {
+	contentReader
+	info *fileInfo
+}
+
+// Stat returns the information describing the file.
+func (file *openFile) Stat() (fs.FileInfo, error) {
+	return file.info, nil
+}
+
+// Close closes the file. As the contents are held in memory there is nothing
+// to release.
+func (file *openFile) Close() error {
+	return nil
+}
+
+// openDir is an embedded directory that has been opened through the fs.FS
+// interface. It tracks how many of its entries have been read so far.
+type openDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	offset  int
+}
+
+// Stat returns the information describing the directory.
+func (dir *openDir) Stat() (fs.FileInfo, error) {
+	return dir.info, nil
+}
+
+// Read always fails, as a directory has no contents to read.
+func (dir *openDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: dir.info.Name(), Err: fs.ErrInvalid}
+}
+
+// Close closes the directory. As the entries are held in memory there is
+// nothing to release.
+func (dir *openDir) Close() error {
+	return nil
+}
+
+// Seek rewinds the directory so that its entries can be read again. Only
+// seeking to the start of the directory is supported.
+func (dir *openDir) Seek(offset int64, whence int) (int64, error) {
+	if offset != 0 || whence != io.SeekStart {
+		err := &fs.PathError{Op: "seek", Path: dir.info.Name(), Err: fs.ErrInvalid}
+		return 0, err
+	}
+
+	dir.offset = 0
+	return 0, nil
+}
+
+// ReadDir returns the next n entries in the directory, fulfilling the
+// fs.ReadDirFile interface. If n <= 0, all remaining entries are returned.
+func (dir *openDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := len(dir.entries) - dir.offset
+
+	if n <= 0 {
+		entries := dir.entries[dir.offset:]
+		dir.offset = len(dir.entries)
+		return entries, nil
+	}
+
+	if remaining == 0 {
+		return nil, io.EOF
+	}
+
+	if n > remaining {
+		n = remaining
+	}
+
+	entries := dir.entries[dir.offset : dir.offset+n]
+	dir.offset += n
+	return entries, nil
+}
@@ -0,0 +1,129 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package zapped
+
+import (
+	"errors"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+// accountingPath is the path, relative to this package, of the directory that
+// the embedded directories built by the tests mirror.
+const accountingPath = "../testdata/accounting"
+
+// embeddedFile returns a File with the provided contents, as zap would embed
+// it.
+func embeddedFile(body string) File {
+	return File{
+		contents: body,
+		size:     int64(len(body)),
+		mode:     0644,
+		modTime:  1590192000000000000,
+	}
+}
+
+// embeddedAccounting returns a Directory matching the one zap would generate
+// for the testdata/accounting directory.
+func embeddedAccounting() *Directory {
+	clients := &Directory{
+		directories: make(map[string]*Directory),
+		files: map[string]File{
+			"a.txt": embeddedFile("AccountName: A\nBalance: 243512.34"),
+			"b.txt": embeddedFile("AccountName: B\nBalance: 748362.34"),
+		},
+	}
+
+	return &Directory{
+		directories: map[string]*Directory{"clients": clients},
+		files: map[string]File{
+			"data.txt": embeddedFile("AccountName: jordanockoljic\nBalance: 143.50"),
+		},
+	}
+}
+
+// runAccounting runs fn as a subtest with each kind of Directory matching the
+// testdata/accounting directory: one that is embedded, and one that is read
+// from the filesystem, as in development mode. onDisk reports which of them
+// the subtest has been given.
+func runAccounting(
+	t *testing.T,
+	fn func(t *testing.T, dir *Directory, onDisk bool),
+) {
+	t.Helper()
+
+	t.Run("Embedded", func(s *testing.T) {
+		fn(s, embeddedAccounting(), false)
+	})
+
+	t.Run("Disk", func(s *testing.T) {
+		fn(s, &Directory{diskPath: accountingPath}, true)
+	})
+}
+
+// setDevelopmentMode changes the mode zapped is running in for the duration
+// of the test.
+func setDevelopmentMode(t *testing.T, mode bool) {
+	t.Helper()
+
+	previous := developmentMode
+	developmentMode = mode
+
+	t.Cleanup(func() {
+		developmentMode = previous
+	})
+}
+
+// assertString will assert that the actual string matches the expected string.
+func assertString(t *testing.T, expected, actual string) {
+	t.Helper()
+	if expected != actual {
+		t.Errorf("Expected %s got %s", expected, actual)
+	}
+}
+
+func TestDirectoryFS(t *testing.T) {
+	runAccounting(t, func(s *testing.T, dir *Directory, onDisk bool) {
+		err := fstest.TestFS(
+			dir,
+			"data.txt",
+			"clients/a.txt",
+			"clients/b.txt")
+
+		if err != nil {
+			s.Error(err.Error())
+		}
+
+		body, err := fs.ReadFile(dir, "clients/b.txt")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "AccountName: B\nBalance: 748362.34", string(body))
+
+		_, err = dir.Open("clients/c.txt")
+		if !errors.Is(err, fs.ErrNotExist) {
+			s.Errorf("expected fs.ErrNotExist, got %v", err)
+		}
+
+		_, err = dir.Open("../accounting/data.txt")
+		if !errors.Is(err, fs.ErrInvalid) {
+			s.Errorf("expected fs.ErrInvalid, got %v", err)
+		}
+	})
+}
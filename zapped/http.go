@@ -0,0 +1,188 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// As an exception, you may distribute programs that contain code generated
+// with or copied into by this program under terms of your choice.
+
+package zapped
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// HTTPFileSystem returns an http.FileSystem that serves the contents of the
+// provided Directory. The files it opens support Seek, Readdir and Stat, both
+// when embedded and when being read from the filesystem in development mode.
+func HTTPFileSystem(dir *Directory) http.FileSystem {
+	return http.FS(dir)
+}
+
+// FileServer returns a handler that serves HTTP requests with the contents of
+// the provided Directory. It behaves the same as http.FileServer.
+func FileServer(dir *Directory) http.Handler {
+	return http.FileServer(HTTPFileSystem(dir))
+}
+
+// CompressedFileServer returns a handler that serves HTTP requests with the
+// contents of the provided Directory, making use of the work zap did when the
+// files were embedded. Files that zap stored compressed are sent as they are to
+// clients that accept gzip, and to other clients without compression. Every
+// file is given a strong ETag from the hash of its contents, and conditional
+// and range requests are handled by http.ServeContent. Directories, and paths
+// that don't lead to a file, are handled the same as FileServer.
+func CompressedFileServer(dir *Directory) http.Handler {
+	fallback := FileServer(dir)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upath := r.URL.Path
+
+		// http.FileServer redirects these paths, so leave them to it.
+		if strings.HasSuffix(upath, "/") ||
+			strings.HasSuffix(upath, "/index.html") {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+upath), "/")
+
+		file, err := dir.File(name)
+		if err != nil {
+			fallback.ServeHTTP(w, r)
+			return
+		}
+
+		serveFile(w, r, &file)
+	})
+}
+
+// serveFile replies to the request with the contents of the file, choosing
+// between the compressed and uncompressed contents based on what the client
+// accepts.
+func serveFile(w http.ResponseWriter, r *http.Request, file *File) {
+	info, err := file.Stat()
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+
+	hash, err := file.Hash()
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+
+	header := w.Header()
+	header.Add("Vary", "Accept-Encoding")
+
+	// The compressed contents are read directly, rather than through
+	// Compressed, so that they aren't copied for every request.
+	if file.diskPath == "" && file.compressed != nil && acceptsGzip(r) {
+		gz := file.compressed.gzip
+
+		// http.ServeContent would detect the type of the compressed contents,
+		// so the type must be worked out from the original contents instead.
+		if header.Get("Content-Type") == "" {
+			ctype := mime.TypeByExtension(path.Ext(info.Name()))
+			if ctype == "" {
+				contents, err := file.read()
+				if err != nil {
+					serveError(w, err)
+					return
+				}
+
+				if len(contents) > 512 {
+					contents = contents[:512]
+				}
+
+				ctype = http.DetectContentType(contents)
+			}
+
+			header.Set("Content-Type", ctype)
+		}
+
+		header.Set("Content-Encoding", "gzip")
+		header.Set("ETag", `"`+hash+`-gzip"`)
+		content := strings.NewReader(gz)
+		http.ServeContent(w, r, info.Name(), info.ModTime(), content)
+		return
+	}
+
+	reader, err := file.Open()
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+
+	defer reader.Close()
+
+	header.Set("ETag", `"`+hash+`"`)
+	http.ServeContent(w, r, info.Name(), info.ModTime(), reader)
+}
+
+// serveError replies to the request with the status code that best describes
+// the error.
+func serveError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	if errors.Is(err, ErrNotExist) {
+		code = http.StatusNotFound
+	}
+
+	http.Error(w, http.StatusText(code), code)
+}
+
+// acceptsGzip reports whether the Accept-Encoding header of the request allows
+// the response to be compressed with gzip, either by naming it or with a
+// wildcard, and without giving it a quality of zero.
+func acceptsGzip(r *http.Request) bool {
+	gzipQuality, anyQuality := -1.0, -1.0
+
+	for _, header := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(header, ",") {
+			params := strings.Split(coding, ";")
+			quality := 1.0
+
+			for _, param := range params[1:] {
+				param = strings.TrimSpace(param)
+				if strings.HasPrefix(param, "q=") {
+					q, err := strconv.ParseFloat(param[2:], 64)
+					if err != nil {
+						q = 0
+					}
+
+					quality = q
+				}
+			}
+
+			switch strings.ToLower(strings.TrimSpace(params[0])) {
+			case "gzip", "x-gzip":
+				gzipQuality = quality
+			case "*":
+				anyQuality = quality
+			}
+		}
+	}
+
+	if gzipQuality >= 0 {
+		return gzipQuality > 0
+	}
+
+	return anyQuality > 0
+}
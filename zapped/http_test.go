@@ -0,0 +1,211 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package zapped
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFileServer(t *testing.T) {
+	runAccounting(t, func(s *testing.T, dir *Directory, onDisk bool) {
+		handler := FileServer(dir)
+
+		get := func(target string, header http.Header) *http.Response {
+			req := httptest.NewRequest(http.MethodGet, target, nil)
+			for name, values := range header {
+				req.Header[name] = values
+			}
+
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			return rec.Result()
+		}
+
+		res := get("/clients/a.txt", nil)
+		if res.StatusCode != http.StatusOK {
+			s.Fatalf("expected status 200, got %d", res.StatusCode)
+		}
+
+		rec := httptest.NewRecorder()
+		rec.Body.ReadFrom(res.Body)
+		assertString(s, "AccountName: A\nBalance: 243512.34", rec.Body.String())
+
+		res = get("/clients/a.txt", http.Header{"Range": {"bytes=0-10"}})
+		if res.StatusCode != http.StatusPartialContent {
+			s.Errorf("expected status 206, got %d", res.StatusCode)
+		}
+
+		res = get("/clients/", nil)
+		rec = httptest.NewRecorder()
+		rec.Body.ReadFrom(res.Body)
+
+		listing := rec.Body.String()
+		if !strings.Contains(listing, "a.txt") ||
+			!strings.Contains(listing, "b.txt") {
+			s.Errorf("expected listing of clients, got %s", listing)
+		}
+
+		res = get("/clients/c.txt", nil)
+		if res.StatusCode != http.StatusNotFound {
+			s.Errorf("expected status 404, got %d", res.StatusCode)
+		}
+	})
+}
+
+func TestCompressedFileServer(t *testing.T) {
+	large := strings.Repeat("AccountName: A\nBalance: 243512.34\n", 64)
+	small := "AccountName: B\nBalance: 748362.34"
+	hash := func(body string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(body)))
+	}
+
+	clients := &Directory{
+		directories: make(map[string]*Directory),
+		files: map[string]File{
+			"a.txt": compressedFile(t, large),
+			"b.txt": embeddedFile(small),
+		},
+	}
+
+	dir := &Directory{
+		directories: map[string]*Directory{"clients": clients},
+		files:       make(map[string]File),
+	}
+
+	handler := CompressedFileServer(dir)
+
+	get := func(target string, header http.Header) (*http.Response, string) {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		for name, values := range header {
+			req.Header[name] = values
+		}
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Result(), rec.Body.String()
+	}
+
+	gzipped := http.Header{"Accept-Encoding": {"deflate, gzip"}}
+
+	t.Run("Gzip", func(s *testing.T) {
+		res, body := get("/clients/a.txt", gzipped)
+		if res.StatusCode != http.StatusOK {
+			s.Fatalf("expected status 200, got %d", res.StatusCode)
+		}
+
+		assertString(s, "gzip", res.Header.Get("Content-Encoding"))
+		assertString(s, `"`+hash(large)+`-gzip"`, res.Header.Get("ETag"))
+		assertString(s, "Accept-Encoding", res.Header.Get("Vary"))
+		assertString(s, "text/plain; charset=utf-8", res.Header.Get("Content-Type"))
+
+		gz, err := gzip.NewReader(strings.NewReader(body))
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		contents, err := ioutil.ReadAll(gz)
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, large, string(contents))
+	})
+
+	t.Run("Identity", func(s *testing.T) {
+		headers := []http.Header{
+			nil,
+			{"Accept-Encoding": {"gzip;q=0, deflate"}},
+			{"Accept-Encoding": {"*;q=0"}},
+		}
+
+		for _, header := range headers {
+			res, body := get("/clients/a.txt", header)
+
+			assertString(s, "", res.Header.Get("Content-Encoding"))
+			assertString(s, `"`+hash(large)+`"`, res.Header.Get("ETag"))
+			assertString(s, large, body)
+		}
+
+		res, body := get("/clients/b.txt", gzipped)
+
+		assertString(s, "", res.Header.Get("Content-Encoding"))
+		assertString(s, `"`+hash(small)+`"`, res.Header.Get("ETag"))
+		assertString(s, small, body)
+	})
+
+	t.Run("IfNoneMatch", func(s *testing.T) {
+		res, _ := get("/clients/a.txt", http.Header{
+			"Accept-Encoding": {"gzip"},
+			"If-None-Match":   {`"` + hash(large) + `-gzip"`},
+		})
+
+		if res.StatusCode != http.StatusNotModified {
+			s.Errorf("expected status 304, got %d", res.StatusCode)
+		}
+
+		// The ETag of the compressed contents must not match the uncompressed
+		// contents.
+		res, _ = get("/clients/a.txt", http.Header{
+			"If-None-Match": {`"` + hash(large) + `-gzip"`},
+		})
+
+		if res.StatusCode != http.StatusOK {
+			s.Errorf("expected status 200, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("Range", func(s *testing.T) {
+		res, body := get("/clients/a.txt", http.Header{"Range": {"bytes=0-10"}})
+		if res.StatusCode != http.StatusPartialContent {
+			s.Errorf("expected status 206, got %d", res.StatusCode)
+		}
+
+		assertString(s, large[:11], body)
+	})
+
+	t.Run("Fallback", func(s *testing.T) {
+		res, body := get("/clients/", nil)
+		if res.StatusCode != http.StatusOK || !strings.Contains(body, "a.txt") {
+			s.Errorf("expected listing of clients, got %s", body)
+		}
+
+		res, _ = get("/clients/c.txt", nil)
+		if res.StatusCode != http.StatusNotFound {
+			s.Errorf("expected status 404, got %d", res.StatusCode)
+		}
+	})
+
+	t.Run("Development", func(s *testing.T) {
+		handler := CompressedFileServer(&Directory{diskPath: accountingPath})
+		req := httptest.NewRequest(http.MethodGet, "/clients/b.txt", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		assertString(s, "", rec.Header().Get("Content-Encoding"))
+		assertString(s, `"`+hash(small)+`"`, rec.Header().Get("ETag"))
+		assertString(s, small, rec.Body.String())
+	})
+}
@@ -0,0 +1,295 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// As an exception, you may distribute programs that contain code generated
+// with or copied into by this program under terms of your choice.
+
+// Package migrations runs versioned SQL migrations that have been embedded
+// with zapped. It reads from an fs.FS rather than a zapped.Directory, so that
+// it doesn't need to know the import path of the zapped library, but any
+// Directory can be passed to it.
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// fileName matches the names of migration files, such as 0001_init.up.sql,
+// capturing the version, name and direction.
+var fileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a single versioned change to a database. Up applies the change
+// and Down reverses it. Down may be empty if the migration can't be reversed.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status describes a Migration, and whether it has been applied to the
+// database.
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// Migrator applies migrations to a database, tracking which versions have
+// been applied in a table. Table defaults to schema_migrations, and can be
+// changed before any migrations are run.
+type Migrator struct {
+	Table string
+
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New reads the migrations from the root of fsys and returns a Migrator that
+// applies them to db. Migration files are named with their version, a name and
+// their direction, such as 0001_init.up.sql and 0001_init.down.sql. Every
+// migration must have an up file, and files that aren't named this way are
+// ignored.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf(
+				"migration %s has a bad version: %w",
+				entry.Name(), err)
+		}
+
+		body, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := found[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			found[version] = migration
+		}
+
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf(
+				"migrations %d_%s and %d_%s share a version",
+				version, migration.Name, version, match[2])
+		}
+
+		switch match[3] {
+		case "up":
+			migration.Up = string(body)
+		case "down":
+			migration.Down = string(body)
+		}
+	}
+
+	m := &Migrator{Table: "schema_migrations", db: db}
+	for _, migration := range found {
+		if migration.Up == "" {
+			return nil, fmt.Errorf(
+				"migration %d_%s has no up file",
+				migration.Version, migration.Name)
+		}
+
+		m.migrations = append(m.migrations, *migration)
+	}
+
+	sort.Slice(m.migrations, func(i, j int) bool {
+		return m.migrations[i].Version < m.migrations[j].Version
+	})
+
+	return m, nil
+}
+
+// Migrations returns all of the migrations, ordered by version.
+func (m *Migrator) Migrations() []Migration {
+	return append([]Migration(nil), m.migrations...)
+}
+
+// applied creates the table used to track migrations if it doesn't already
+// exist, and returns the versions that have been applied.
+func (m *Migrator) applied() (map[int64]bool, error) {
+	create := fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (version BIGINT PRIMARY KEY)",
+		m.Table)
+
+	if _, err := m.db.Exec(create); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.Query(fmt.Sprintf("SELECT version FROM %s", m.Table))
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	versions := make(map[int64]bool)
+	for rows.Next() {
+		var version int64
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+
+		versions[version] = true
+	}
+
+	return versions, rows.Err()
+}
+
+// run executes the SQL of a migration in a transaction, along with the
+// statement recording that it has been applied or reverted, so that either
+// both happen or neither do.
+func (m *Migrator) run(migration Migration, up bool) error {
+	body := migration.Up
+	record := fmt.Sprintf(
+		"INSERT INTO %s (version) VALUES (%d)",
+		m.Table, migration.Version)
+
+	if !up {
+		body = migration.Down
+		record = fmt.Sprintf(
+			"DELETE FROM %s WHERE version = %d",
+			m.Table, migration.Version)
+	}
+
+	if body == "" {
+		return fmt.Errorf(
+			"migration %d_%s has no down file",
+			migration.Version, migration.Name)
+	}
+
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, statement := range []string{body, record} {
+		if _, err := tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return fmt.Errorf(
+				"migration %d_%s failed: %w",
+				migration.Version, migration.Name, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Up applies every migration that hasn't been applied yet, in order of their
+// versions.
+func (m *Migrator) Up() error {
+	return m.migrate(func(Migration) bool { return true })
+}
+
+// Down reverts the most recently applied migration. If no migrations have
+// been applied, nothing happens.
+func (m *Migrator) Down() error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		if applied[m.migrations[i].Version] {
+			return m.run(m.migrations[i], false)
+		}
+	}
+
+	return nil
+}
+
+// To applies or reverts migrations so that every migration up to and including
+// version has been applied, and none after it have. A version of zero reverts
+// every migration.
+func (m *Migrator) To(version int64) error {
+	known := version == 0
+	for _, migration := range m.migrations {
+		known = known || migration.Version == version
+	}
+
+	if !known {
+		return fmt.Errorf("no migration has version %d", version)
+	}
+
+	return m.migrate(func(migration Migration) bool {
+		return migration.Version <= version
+	})
+}
+
+// migrate reverts the applied migrations that shouldn't be applied, newest
+// first, and then applies those that should be, oldest first.
+func (m *Migrator) migrate(wanted func(Migration) bool) error {
+	applied, err := m.applied()
+	if err != nil {
+		return err
+	}
+
+	for i := len(m.migrations) - 1; i >= 0; i-- {
+		migration := m.migrations[i]
+		if applied[migration.Version] && !wanted(migration) {
+			if err := m.run(migration, false); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, migration := range m.migrations {
+		if !applied[migration.Version] && wanted(migration) {
+			if err := m.run(migration, true); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Status returns every migration, ordered by version, along with whether it
+// has been applied.
+func (m *Migrator) Status() ([]Status, error) {
+	var statuses []Status
+
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, migration := range m.migrations {
+		statuses = append(statuses, Status{
+			Migration: migration,
+			Applied:   applied[migration.Version],
+		})
+	}
+
+	return statuses, nil
+}
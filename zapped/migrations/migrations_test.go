@@ -0,0 +1,376 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+// fakeDatabase is the state shared by every connection to the fake driver. It
+// records the migration statements that have been committed, and the versions
+// in the migrations table.
+type fakeDatabase struct {
+	mu         sync.Mutex
+	statements []string
+	versions   map[int64]bool
+}
+
+// fakeDriver is a database/sql driver that understands just enough SQL to
+// track migrations. Any statement containing FAIL returns an error. It is also
+// its own driver.Connector, so that each test can open a database with its own
+// state without registering a driver.
+type fakeDriver struct {
+	db *fakeDatabase
+}
+
+func (d *fakeDriver) Open(string) (driver.Conn, error) {
+	return &fakeConn{db: d.db}, nil
+}
+
+func (d *fakeDriver) Connect(context.Context) (driver.Conn, error) {
+	return d.Open("")
+}
+
+func (d *fakeDriver) Driver() driver.Driver {
+	return d
+}
+
+// fakeConn is a connection to the fake database. Statements executed inside a
+// transaction are only applied to the database when it is committed.
+type fakeConn struct {
+	db      *fakeDatabase
+	pending []string
+	inTx    bool
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{conn: c, query: query}, nil
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.inTx = true
+	c.pending = nil
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+
+	for _, query := range c.pending {
+		c.db.apply(query)
+	}
+
+	c.inTx = false
+	c.pending = nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.inTx = false
+	c.pending = nil
+	return nil
+}
+
+// apply updates the state of the database with the statement.
+func (db *fakeDatabase) apply(query string) {
+	var version int64
+
+	switch {
+	case strings.HasPrefix(query, "CREATE TABLE IF NOT EXISTS"):
+	case strings.HasPrefix(query, "INSERT INTO"):
+		fmt.Sscanf(query, "INSERT INTO schema_migrations (version) VALUES (%d)", &version)
+		db.versions[version] = true
+	case strings.HasPrefix(query, "DELETE FROM"):
+		fmt.Sscanf(query, "DELETE FROM schema_migrations WHERE version = %d", &version)
+		delete(db.versions, version)
+	default:
+		db.statements = append(db.statements, query)
+	}
+}
+
+// fakeStmt is a prepared statement on the fake database.
+type fakeStmt struct {
+	conn  *fakeConn
+	query string
+}
+
+func (s *fakeStmt) Close() error {
+	return nil
+}
+
+func (s *fakeStmt) NumInput() int {
+	return 0
+}
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	if strings.Contains(s.query, "FAIL") {
+		return nil, errors.New("statement failed")
+	}
+
+	if s.conn.inTx {
+		s.conn.pending = append(s.conn.pending, s.query)
+		return driver.RowsAffected(1), nil
+	}
+
+	s.conn.db.mu.Lock()
+	defer s.conn.db.mu.Unlock()
+
+	s.conn.db.apply(s.query)
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	s.conn.db.mu.Lock()
+	defer s.conn.db.mu.Unlock()
+
+	var versions []int64
+	for version := range s.conn.db.versions {
+		versions = append(versions, version)
+	}
+
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i] < versions[j]
+	})
+
+	return &fakeRows{versions: versions}, nil
+}
+
+// fakeRows returns the versions in the migrations table.
+type fakeRows struct {
+	versions []int64
+}
+
+func (r *fakeRows) Columns() []string {
+	return []string{"version"}
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.versions) == 0 {
+		return io.EOF
+	}
+
+	dest[0] = r.versions[0]
+	r.versions = r.versions[1:]
+	return nil
+}
+
+// openFake returns a connection to a new fake database, along with the state
+// of the database.
+func openFake(t *testing.T) (*sql.DB, *fakeDatabase) {
+	t.Helper()
+
+	state := &fakeDatabase{versions: make(map[int64]bool)}
+	db := sql.OpenDB(&fakeDriver{db: state})
+
+	t.Cleanup(func() {
+		db.Close()
+	})
+
+	return db, state
+}
+
+// migrationFiles are the files used by most of the tests.
+var migrationFiles = fstest.MapFS{
+	"0001_init.up.sql":        {Data: []byte("up 1")},
+	"0001_init.down.sql":      {Data: []byte("down 1")},
+	"0002_users.up.sql":       {Data: []byte("up 2")},
+	"0002_users.down.sql":     {Data: []byte("down 2")},
+	"0010_indexes.up.sql":     {Data: []byte("up 10")},
+	"0010_indexes.down.sql":   {Data: []byte("down 10")},
+	"README.md":               {Data: []byte("ignored")},
+	"archive/0003_old.up.sql": {Data: []byte("ignored")},
+}
+
+// assertStatements will assert that the migration statements executed against
+// the database match the expected ones.
+func assertStatements(t *testing.T, db *fakeDatabase, expected ...string) {
+	t.Helper()
+
+	if !reflect.DeepEqual(expected, db.statements) {
+		t.Errorf("expected statements %v got %v", expected, db.statements)
+	}
+}
+
+// assertVersions will assert that the versions recorded as applied match the
+// expected ones.
+func assertVersions(t *testing.T, m *Migrator, expected ...int64) {
+	t.Helper()
+
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var applied []int64
+	for _, status := range statuses {
+		if status.Applied {
+			applied = append(applied, status.Version)
+		}
+	}
+
+	if !reflect.DeepEqual(expected, applied) {
+		t.Errorf("expected versions %v got %v", expected, applied)
+	}
+}
+
+func TestNew(t *testing.T) {
+	db, _ := openFake(t)
+
+	m, err := New(db, migrationFiles)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	expected := []Migration{
+		{Version: 1, Name: "init", Up: "up 1", Down: "down 1"},
+		{Version: 2, Name: "users", Up: "up 2", Down: "down 2"},
+		{Version: 10, Name: "indexes", Up: "up 10", Down: "down 10"},
+	}
+
+	if !reflect.DeepEqual(expected, m.Migrations()) {
+		t.Errorf("expected %v got %v", expected, m.Migrations())
+	}
+
+	bad := []fstest.MapFS{
+		{"0001_init.down.sql": {Data: []byte("down 1")}},
+		{
+			"0001_init.up.sql":  {Data: []byte("up 1")},
+			"0001_users.up.sql": {Data: []byte("up 1")},
+		},
+	}
+
+	for _, fsys := range bad {
+		if _, err := New(db, fsys); err == nil {
+			t.Errorf("expected an error reading %v", fsys)
+		}
+	}
+}
+
+func TestUpAndDown(t *testing.T) {
+	db, state := openFake(t)
+
+	m, err := New(db, migrationFiles)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertVersions(t, m)
+
+	if err := m.Up(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertVersions(t, m, 1, 2, 10)
+	assertStatements(t, state, "up 1", "up 2", "up 10")
+
+	if err := m.Down(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertVersions(t, m, 1, 2)
+	assertStatements(t, state, "up 1", "up 2", "up 10", "down 10")
+
+	// Running Up again should only apply what is missing.
+	if err := m.Up(); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertVersions(t, m, 1, 2, 10)
+	assertStatements(t, state, "up 1", "up 2", "up 10", "down 10", "up 10")
+}
+
+func TestTo(t *testing.T) {
+	db, state := openFake(t)
+
+	m, err := New(db, migrationFiles)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := m.To(2); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertVersions(t, m, 1, 2)
+
+	if err := m.To(10); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertVersions(t, m, 1, 2, 10)
+
+	if err := m.To(0); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertVersions(t, m)
+	assertStatements(t, state,
+		"up 1", "up 2", "up 10", "down 10", "down 2", "down 1")
+
+	if err := m.To(3); err == nil {
+		t.Error("expected an error migrating to an unknown version")
+	}
+}
+
+func TestFailedMigrationIsRolledBack(t *testing.T) {
+	db, state := openFake(t)
+
+	files := fstest.MapFS{
+		"0001_init.up.sql":   {Data: []byte("up 1")},
+		"0002_broken.up.sql": {Data: []byte("FAIL")},
+		"0003_later.up.sql":  {Data: []byte("up 3")},
+	}
+
+	m, err := New(db, files)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	if err := m.Up(); err == nil {
+		t.Fatal("expected the broken migration to fail")
+	}
+
+	assertVersions(t, m, 1)
+	assertStatements(t, state, "up 1")
+
+	// Migrations without a down file can't be reverted.
+	if err := m.Down(); err == nil {
+		t.Error("expected an error reverting without a down file")
+	}
+
+	assertVersions(t, m, 1)
+}
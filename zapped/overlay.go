@@ -0,0 +1,86 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// As an exception, you may distribute programs that contain code generated
+// with or copied into by this program under terms of your choice.
+
+package zapped
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// overlays stores the overlay roots that have been set with SetOverlay, keyed
+// by the Key of the resource they overlay.
+var overlays = struct {
+	sync.RWMutex
+	roots map[string]string
+}{roots: make(map[string]string)}
+
+// SetOverlay configures a directory on the filesystem to overlay the embedded
+// resource with the provided key. Once set, the Directory returned by Resource
+// will read files from beneath root when they exist there, and fall back to
+// the embedded files when they don't. Passing an empty root removes the
+// overlay. Overlays have no effect in development mode, as every file is
+// already read from the filesystem.
+func SetOverlay(key string, root string) {
+	overlays.Lock()
+	defer overlays.Unlock()
+
+	if root == "" {
+		delete(overlays.roots, key)
+		return
+	}
+
+	overlays.roots[key] = root
+}
+
+// OverlayEnv returns the name of the environment variable that can be used to
+// overlay the resource with the provided key, without calling SetOverlay. The
+// name is ZAPPED_OVERLAY_ followed by the key in upper case, with any
+// character other than a letter, digit or underscore replaced with an
+// underscore.
+func OverlayEnv(key string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+
+		return '_'
+	}, key)
+
+	return "ZAPPED_OVERLAY_" + name
+}
+
+// overlayRoot returns the root of the overlay for the resource with the
+// provided key, preferring one set with SetOverlay over the environment. If
+// the resource isn't overlaid, empty string is returned.
+func overlayRoot(key string) string {
+	overlays.RLock()
+	root, ok := overlays.roots[key]
+	overlays.RUnlock()
+
+	if ok {
+		return root
+	}
+
+	return os.Getenv(OverlayEnv(key))
+}
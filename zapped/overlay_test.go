@@ -0,0 +1,155 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package zapped
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// setResource embeds the provided Directory under the key for the duration of
+// the test.
+func setResource(t *testing.T, key string, dir *Directory) {
+	t.Helper()
+
+	resources[key] = dir
+
+	t.Cleanup(func() {
+		delete(resources, key)
+	})
+}
+
+// writeFiles creates each of the files, relative to the root directory.
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+
+	for name, body := range files {
+		fpath := filepath.Join(root, filepath.FromSlash(name))
+
+		err := os.MkdirAll(filepath.Dir(fpath), 0755)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+
+		err = ioutil.WriteFile(fpath, []byte(body), 0644)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	setDevelopmentMode(t, false)
+	setResource(t, "ACCOUNTING", embeddedAccounting())
+
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"data.txt":      "AccountName: patched\nBalance: 0.00",
+		"clients/c.txt": "AccountName: C\nBalance: 12.00",
+		"extra/x.txt":   "x",
+	})
+
+	t.Run("SetOverlay", func(s *testing.T) {
+		SetOverlay("ACCOUNTING", root)
+		s.Cleanup(func() {
+			SetOverlay("ACCOUNTING", "")
+		})
+
+		dir, err := Resource("ACCOUNTING", "")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		files := map[string]string{
+			"data.txt":      "AccountName: patched\nBalance: 0.00",
+			"clients/a.txt": "AccountName: A\nBalance: 243512.34",
+			"clients/c.txt": "AccountName: C\nBalance: 12.00",
+			"extra/x.txt":   "x",
+		}
+
+		for name, expected := range files {
+			file, err := dir.File(name)
+			if err != nil {
+				s.Fatal(err.Error())
+			}
+
+			assertString(s, expected, file.String())
+		}
+
+		assertStringSliceMatch(s, []string{"clients", "extra"}, dir.Directories())
+
+		clients, err := dir.Directory("clients")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertStringSliceMatch(s, []string{"a.txt", "b.txt", "c.txt"}, clients.Files())
+
+		err = fstest.TestFS(dir, "data.txt", "clients/b.txt", "clients/c.txt")
+		if err != nil {
+			s.Error(err.Error())
+		}
+	})
+
+	t.Run("Environment", func(s *testing.T) {
+		env := OverlayEnv("ACCOUNTING")
+		assertString(s, "ZAPPED_OVERLAY_ACCOUNTING", env)
+
+		os.Setenv(env, root)
+		s.Cleanup(func() {
+			os.Unsetenv(env)
+		})
+
+		dir, err := Resource("ACCOUNTING", "")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		file, err := dir.File("data.txt")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "AccountName: patched\nBalance: 0.00", file.String())
+	})
+
+	t.Run("NotOverlaid", func(s *testing.T) {
+		dir, err := Resource("ACCOUNTING", "")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		file, err := dir.File("data.txt")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "AccountName: jordanockoljic\nBalance: 143.50", file.String())
+
+		if _, err := dir.File("clients/c.txt"); err == nil {
+			s.Error("expected clients/c.txt to only exist in the overlay")
+		}
+	})
+}
+
+func TestOverlayEnv(t *testing.T) {
+	assertString(t, "ZAPPED_OVERLAY_STATIC_ASSETS", OverlayEnv("static-assets"))
+	assertString(t, "ZAPPED_OVERLAY_SQL_V2", OverlayEnv("sql_v2"))
+}
@@ -0,0 +1,310 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// As an exception, you may distribute programs that contain code generated
+// with or copied into by this program under terms of your choice.
+
+package zapped
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"path"
+	"sync"
+	texttemplate "text/template"
+)
+
+// templateSource reads the files matching a set of patterns from a Directory,
+// keeping track of what they looked like when they were read so that changes
+// can be detected.
+type templateSource struct {
+	dir      *Directory
+	patterns []string
+	stamps   map[string]fileStamp
+}
+
+// match returns the paths of all the files matching the patterns in the order
+// of the patterns, along with their stamps. Each pattern must match at least
+// one file.
+func (src *templateSource) match() ([]string, map[string]fileStamp, error) {
+	var names []string
+	stamps := make(map[string]fileStamp)
+
+	if len(src.patterns) == 0 {
+		return nil, nil, src.dir.pathError("template", ".", ErrInvalid)
+	}
+
+	for _, pattern := range src.patterns {
+		matches, err := src.dir.Glob(pattern)
+		if err != nil {
+			return nil, nil, src.dir.pathError("template", pattern, err)
+		}
+
+		found := false
+		for _, name := range matches {
+			info, err := src.dir.Stat(name)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			if info.IsDir() {
+				continue
+			}
+
+			found = true
+			if _, seen := stamps[name]; !seen {
+				names = append(names, name)
+				stamps[name] = fileStamp{
+					size:    info.Size(),
+					modTime: info.ModTime(),
+				}
+			}
+		}
+
+		if !found {
+			err := src.dir.pathError("template", pattern, ErrNotExist)
+			return nil, nil, err
+		}
+	}
+
+	return names, stamps, nil
+}
+
+// read calls fn with the base name and contents of each file matching the
+// patterns. The stamps of the files are only recorded if every call succeeds,
+// so that a failed parse is retried.
+func (src *templateSource) read(fn func(name, body string) error) error {
+	src.stamps = nil
+
+	names, stamps, err := src.match()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		body, err := src.dir.ReadFile(name)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(path.Base(name), string(body)); err != nil {
+			return err
+		}
+	}
+
+	src.stamps = stamps
+	return nil
+}
+
+// stale reports whether the files matching the patterns have changed since
+// they were last read. Only files on the filesystem can change, so embedded
+// templates are never stale.
+func (src *templateSource) stale() bool {
+	if src.dir.diskPath == "" {
+		return false
+	}
+
+	_, stamps, err := src.match()
+	if err != nil || src.stamps == nil || len(stamps) != len(src.stamps) {
+		return true
+	}
+
+	for name, stamp := range stamps {
+		previous, ok := src.stamps[name]
+		if !ok || previous.size != stamp.size ||
+			!previous.modTime.Equal(stamp.modTime) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// HTMLTemplates is a set of html/template templates parsed from the files in
+// a Directory. When the files are being read from the filesystem, such as in
+// development mode, the templates are parsed again whenever the files change.
+type HTMLTemplates struct {
+	source templateSource
+	funcs  htmltemplate.FuncMap
+	mu     sync.Mutex
+	tmpl   *htmltemplate.Template
+}
+
+// ParseHTMLTemplates parses the files within the Directory that match any of
+// the patterns, which use the same syntax as Directory.Glob. Every template is
+// given the functions in funcs, and is named after the base name of its file,
+// the same as with html/template.ParseFS.
+func ParseHTMLTemplates(
+	dir *Directory,
+	funcs htmltemplate.FuncMap,
+	patterns ...string,
+) (*HTMLTemplates, error) {
+	templates := &HTMLTemplates{
+		source: templateSource{dir: dir, patterns: patterns},
+		funcs:  funcs,
+	}
+
+	tmpl, err := templates.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	templates.tmpl = tmpl
+	return templates, nil
+}
+
+// parse reads and parses all of the templates.
+func (t *HTMLTemplates) parse() (*htmltemplate.Template, error) {
+	var root *htmltemplate.Template
+
+	err := t.source.read(func(name, body string) error {
+		tmpl := root
+
+		switch {
+		case root == nil:
+			root = htmltemplate.New(name).Funcs(t.funcs)
+			tmpl = root
+		case name != root.Name():
+			tmpl = root.New(name)
+		}
+
+		_, err := tmpl.Parse(body)
+		return err
+	})
+
+	return root, err
+}
+
+// Template returns the parsed templates. If any of the files have changed on
+// the filesystem since they were last parsed, they are parsed again first.
+func (t *HTMLTemplates) Template() (*htmltemplate.Template, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.source.stale() {
+		tmpl, err := t.parse()
+		if err != nil {
+			return nil, err
+		}
+
+		t.tmpl = tmpl
+	}
+
+	return t.tmpl, nil
+}
+
+// ExecuteTemplate applies the template with the provided name to data, writing
+// the output to w.
+func (t *HTMLTemplates) ExecuteTemplate(
+	w io.Writer,
+	name string,
+	data interface{},
+) error {
+	tmpl, err := t.Template()
+	if err != nil {
+		return err
+	}
+
+	return tmpl.ExecuteTemplate(w, name, data)
+}
+
+// TextTemplates is a set of text/template templates parsed from the files in
+// a Directory. When the files are being read from the filesystem, such as in
+// development mode, the templates are parsed again whenever the files change.
+type TextTemplates struct {
+	source templateSource
+	funcs  texttemplate.FuncMap
+	mu     sync.Mutex
+	tmpl   *texttemplate.Template
+}
+
+// ParseTextTemplates parses the files within the Directory that match any of
+// the patterns, which use the same syntax as Directory.Glob. Every template is
+// given the functions in funcs, and is named after the base name of its file,
+// the same as with text/template.ParseFS.
+func ParseTextTemplates(
+	dir *Directory,
+	funcs texttemplate.FuncMap,
+	patterns ...string,
+) (*TextTemplates, error) {
+	templates := &TextTemplates{
+		source: templateSource{dir: dir, patterns: patterns},
+		funcs:  funcs,
+	}
+
+	tmpl, err := templates.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	templates.tmpl = tmpl
+	return templates, nil
+}
+
+// parse reads and parses all of the templates.
+func (t *TextTemplates) parse() (*texttemplate.Template, error) {
+	var root *texttemplate.Template
+
+	err := t.source.read(func(name, body string) error {
+		tmpl := root
+
+		switch {
+		case root == nil:
+			root = texttemplate.New(name).Funcs(t.funcs)
+			tmpl = root
+		case name != root.Name():
+			tmpl = root.New(name)
+		}
+
+		_, err := tmpl.Parse(body)
+		return err
+	})
+
+	return root, err
+}
+
+// Template returns the parsed templates. If any of the files have changed on
+// the filesystem since they were last parsed, they are parsed again first.
+func (t *TextTemplates) Template() (*texttemplate.Template, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.source.stale() {
+		tmpl, err := t.parse()
+		if err != nil {
+			return nil, err
+		}
+
+		t.tmpl = tmpl
+	}
+
+	return t.tmpl, nil
+}
+
+// ExecuteTemplate applies the template with the provided name to data, writing
+// the output to w.
+func (t *TextTemplates) ExecuteTemplate(
+	w io.Writer,
+	name string,
+	data interface{},
+) error {
+	tmpl, err := t.Template()
+	if err != nil {
+		return err
+	}
+
+	return tmpl.ExecuteTemplate(w, name, data)
+}
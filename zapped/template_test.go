@@ -0,0 +1,132 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package zapped
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	texttemplate "text/template"
+	"time"
+)
+
+// templateFiles are the templates used by the tests, keyed by their path.
+var templateFiles = map[string]string{
+	"layout.html":      `{{ define "layout" }}<h1>{{ upper . }}</h1>{{ end }}`,
+	"pages/index.html": `{{ template "layout" . }}`,
+	"notes.txt":        "not a template",
+}
+
+func TestHTMLTemplates(t *testing.T) {
+	funcs := htmltemplate.FuncMap{"upper": strings.ToUpper}
+
+	t.Run("Embedded", func(s *testing.T) {
+		dir := &Directory{
+			directories: map[string]*Directory{
+				"pages": {
+					files: map[string]File{
+						"index.html": embeddedFile(templateFiles["pages/index.html"]),
+					},
+				},
+			},
+			files: map[string]File{
+				"layout.html": embeddedFile(templateFiles["layout.html"]),
+				"notes.txt":   embeddedFile(templateFiles["notes.txt"]),
+			},
+		}
+
+		templates, err := ParseHTMLTemplates(dir, funcs, "**/*.html")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		var buf bytes.Buffer
+		err = templates.ExecuteTemplate(&buf, "index.html", "<home>")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "<h1>&lt;HOME&gt;</h1>", buf.String())
+
+		_, err = ParseHTMLTemplates(dir, funcs, "*.tmpl")
+		assertPathError(s, "", "*.tmpl", ErrNotExist, err)
+	})
+
+	t.Run("Development", func(s *testing.T) {
+		root := s.TempDir()
+		writeFiles(s, root, templateFiles)
+
+		dir := &Directory{diskPath: root}
+		templates, err := ParseHTMLTemplates(dir, funcs, "**/*.html")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		var buf bytes.Buffer
+		err = templates.ExecuteTemplate(&buf, "index.html", "home")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "<h1>HOME</h1>", buf.String())
+
+		// Change the layout, making sure the modification time differs even
+		// on filesystems with a coarse resolution.
+		layout := filepath.Join(root, "layout.html")
+		writeFiles(s, root, map[string]string{
+			"layout.html": `{{ define "layout" }}<h2>{{ upper . }}</h2>{{ end }}`,
+		})
+
+		later := time.Now().Add(time.Minute)
+		if err := os.Chtimes(layout, later, later); err != nil {
+			s.Fatal(err.Error())
+		}
+
+		buf.Reset()
+		err = templates.ExecuteTemplate(&buf, "index.html", "home")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "<h2>HOME</h2>", buf.String())
+	})
+}
+
+func TestTextTemplates(t *testing.T) {
+	dir := &Directory{
+		files: map[string]File{
+			"greeting.tmpl": embeddedFile(`Hello {{ upper . }}`),
+		},
+	}
+
+	funcs := texttemplate.FuncMap{"upper": strings.ToUpper}
+	templates, err := ParseTextTemplates(dir, funcs, "*.tmpl")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	var buf bytes.Buffer
+	err = templates.ExecuteTemplate(&buf, "greeting.tmpl", "<world>")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+
+	assertString(t, "Hello <WORLD>", buf.String())
+}
@@ -0,0 +1,154 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// As an exception, you may distribute programs that contain code generated
+// with or copied into by this program under terms of your choice.
+
+package zapped
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// VerifyError lists every embedded file that failed verification. Each error
+// is a PathError describing the key and path of the file, which wraps
+// ErrHashMismatch if the contents no longer match their hash.
+type VerifyError struct {
+	Errors []*PathError
+}
+
+// Error returns a description of every file that failed verification, one per
+// line, fulfilling the error interface.
+func (e *VerifyError) Error() string {
+	lines := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		lines[i] = err.Error()
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Is reports whether any of the files failed verification with the target
+// error, so that errors.Is(err, ErrHashMismatch) can be used.
+func (e *VerifyError) Is(target error) bool {
+	for _, err := range e.Errors {
+		if err.Err == target {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verify checks that the embedded contents of the file still match the hash
+// that zap recorded, returning a PathError if they don't. Files without a
+// recorded hash can't be checked, so are always considered valid.
+func (file *File) verify() *PathError {
+	if file.hash == "" {
+		return nil
+	}
+
+	contents, err := file.embeddedContents()
+	if err != nil {
+		return &PathError{Op: "verify", Key: file.key, Path: file.path, Err: err}
+	}
+
+	if fmt.Sprintf("%x", sha256.Sum256(contents)) != file.hash {
+		return &PathError{
+			Op:   "verify",
+			Key:  file.key,
+			Path: file.path,
+			Err:  ErrHashMismatch,
+		}
+	}
+
+	return nil
+}
+
+// verify checks every file embedded within the directory and its
+// subdirectories, returning a PathError for each that fails.
+func (dir *Directory) verify() []*PathError {
+	var errs []*PathError
+
+	for name, file := range dir.files {
+		file.key = dir.key
+		file.path = joinPath(dir.path, name)
+
+		if err := file.verify(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for name, sub := range dir.directories {
+		child := &Directory{
+			directories: sub.directories,
+			files:       sub.files,
+			key:         dir.key,
+			path:        joinPath(dir.path, name),
+		}
+
+		errs = append(errs, child.verify()...)
+	}
+
+	return errs
+}
+
+// Verify hashes the contents of every embedded resource again and checks that
+// they match the hashes zap recorded when embedding them, to prove that they
+// haven't been altered since. Only embedded contents are checked, files that
+// are read from the filesystem are not, and neither are encrypted files, which
+// have no recorded hash since decrypting them already detects any changes. If
+// any file fails verification, a *VerifyError listing all of them, sorted by
+// key and path, is returned.
+func Verify() error {
+	var errs []*PathError
+
+	for key, res := range resources {
+		dir := &Directory{
+			directories: res.directories,
+			files:       res.files,
+			key:         key,
+		}
+
+		errs = append(errs, dir.verify()...)
+	}
+
+	for key, res := range fileResources {
+		file := *res
+		file.key = key
+
+		if err := file.verify(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+
+	sort.Slice(errs, func(i, j int) bool {
+		if errs[i].Key != errs[j].Key {
+			return errs[i].Key < errs[j].Key
+		}
+
+		return errs[i].Path < errs[j].Path
+	})
+
+	return &VerifyError{Errors: errs}
+}
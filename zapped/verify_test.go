@@ -0,0 +1,120 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package zapped
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// hashedFile returns a File with the provided contents, as zap would embed it,
+// along with the hash of the contents.
+func hashedFile(body string) File {
+	file := embeddedFile(body)
+	file.hash = fmt.Sprintf("%x", sha256.Sum256([]byte(body)))
+	return file
+}
+
+func TestFileHash(t *testing.T) {
+	const body = "AccountName: jordanockoljic\nBalance: 143.50"
+	expected := fmt.Sprintf("%x", sha256.Sum256([]byte(body)))
+
+	files := map[string]File{
+		"Recorded":    hashedFile(body),
+		"NotRecorded": embeddedFile(body),
+		"Development": {diskPath: accountingPath + "/data.txt"},
+	}
+
+	for name, file := range files {
+		t.Run(name, func(s *testing.T) {
+			hash, err := file.Hash()
+			if err != nil {
+				s.Fatal(err.Error())
+			}
+
+			assertString(s, expected, hash)
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	clients := &Directory{
+		directories: make(map[string]*Directory),
+		files: map[string]File{
+			"a.txt": hashedFile("AccountName: A\nBalance: 243512.34"),
+			"b.txt": hashedFile("AccountName: B\nBalance: 748362.34"),
+		},
+	}
+
+	accounting := &Directory{
+		directories: map[string]*Directory{"clients": clients},
+		files: map[string]File{
+			"data.txt": hashedFile("AccountName: jordanockoljic\nBalance: 143.50"),
+		},
+	}
+
+	setResource(t, "ACCOUNTING", accounting)
+
+	license := hashedFile("MIT")
+	fileResources["LICENSE"] = &license
+	t.Cleanup(func() {
+		delete(fileResources, "LICENSE")
+	})
+
+	if err := Verify(); err != nil {
+		t.Fatalf("Expected nil got %v", err)
+	}
+
+	// Alter the contents after they were hashed, as if they were tampered
+	// with or corrupted.
+	tamper := func(dir *Directory, name string) {
+		file := dir.files[name]
+		file.contents = "a" + file.contents[1:]
+		dir.files[name] = file
+	}
+
+	tamper(clients, "b.txt")
+	tamper(accounting, "data.txt")
+	license.contents = "m" + license.contents[1:]
+
+	err := Verify()
+	if !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("Expected ErrHashMismatch got %v", err)
+	}
+
+	var verifyErr *VerifyError
+	if !errors.As(err, &verifyErr) {
+		t.Fatalf("Expected a VerifyError got %T", err)
+	}
+
+	errs := verifyErr.Errors
+	if len(errs) != 3 {
+		t.Fatalf("Expected 3 errors got %d", len(errs))
+	}
+
+	assertPathError(t, "ACCOUNTING", "clients/b.txt", ErrHashMismatch, errs[0])
+	assertPathError(t, "ACCOUNTING", "data.txt", ErrHashMismatch, errs[1])
+	assertPathError(t, "LICENSE", "", ErrHashMismatch, errs[2])
+
+	expected := "verify ACCOUNTING:clients/b.txt: hash mismatch\n" +
+		"verify ACCOUNTING:data.txt: hash mismatch\n" +
+		"verify LICENSE: hash mismatch"
+
+	assertString(t, expected, err.Error())
+}
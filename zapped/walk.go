@@ -0,0 +1,127 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// As an exception, you may distribute programs that contain code generated
+// with or copied into by this program under terms of your choice.
+
+package zapped
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Walk walks the tree of files and directories within the Directory, calling
+// fn for each one, including the Directory itself which is given the name ".".
+// Entries are visited in lexical order, and fn may return fs.SkipDir to skip
+// a directory, the same as with fs.WalkDir.
+func (dir *Directory) Walk(fn fs.WalkDirFunc) error {
+	return fs.WalkDir(dir, ".", fn)
+}
+
+// Glob returns the slash-separated paths of all the files and directories
+// within the Directory that match the pattern, in the order they are visited
+// by Walk. Each element of the pattern is matched using path.Match, except for
+// an element of "**", which matches zero or more directories. If the pattern
+// is malformed, path.ErrBadPattern is returned. Otherwise any error
+// encountered while walking, such as a directory on the filesystem that can't
+// be read, is returned along with the matches found before it.
+func (dir *Directory) Glob(pattern string) ([]string, error) {
+	var matches []string
+
+	elems := strings.Split(pattern, "/")
+	for _, elem := range elems {
+		if _, err := path.Match(elem, ""); err != nil {
+			return nil, err
+		}
+	}
+
+	err := dir.Walk(func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if name == "." {
+			return nil
+		}
+
+		nameElems := strings.Split(name, "/")
+		if matchElems(elems, nameElems) {
+			matches = append(matches, name)
+		}
+
+		if entry.IsDir() && !matchPrefix(elems, nameElems) {
+			return fs.SkipDir
+		}
+
+		return nil
+	})
+
+	return matches, err
+}
+
+// matchElems reports whether the elements of a path match the elements of a
+// pattern, where a pattern element of "**" matches zero or more elements.
+func matchElems(pattern, elems []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pattern[1:], elems[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(elems) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(pattern[0], elems[0]); !ok {
+			return false
+		}
+
+		pattern = pattern[1:]
+		elems = elems[1:]
+	}
+
+	return len(elems) == 0
+}
+
+// matchPrefix reports whether anything within the directory described by the
+// provided elements could possibly match the pattern, so that directories that
+// can't contain matches don't need to be walked.
+func matchPrefix(pattern, elems []string) bool {
+	for _, elem := range elems {
+		if len(pattern) == 0 {
+			return false
+		}
+
+		if pattern[0] == "**" {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern[0], elem); !ok {
+			return false
+		}
+
+		pattern = pattern[1:]
+	}
+
+	return len(pattern) > 0
+}
@@ -0,0 +1,121 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package zapped
+
+import (
+	"io/fs"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// assertStringSliceMatch will assert the actual provided string slice matches
+// the expected one.
+func assertStringSliceMatch(t *testing.T, expected, actual []string) {
+	t.Helper()
+
+	if len(expected)+len(actual) == 0 {
+		return
+	}
+
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("Expected %v got %v", expected, actual)
+	}
+}
+
+func TestDirectoryWalk(t *testing.T) {
+	runAccounting(t, func(s *testing.T, dir *Directory, onDisk bool) {
+		walk := func(skip string) []string {
+			var visited []string
+
+			err := dir.Walk(func(name string, _ fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+
+				visited = append(visited, name)
+
+				if name == skip {
+					return fs.SkipDir
+				}
+
+				return nil
+			})
+
+			if err != nil {
+				s.Fatal(err.Error())
+			}
+
+			return visited
+		}
+
+		assertStringSliceMatch(s, []string{
+			".",
+			"clients",
+			"clients/a.txt",
+			"clients/b.txt",
+			"data.txt",
+		}, walk(""))
+
+		assertStringSliceMatch(s, []string{
+			".",
+			"clients",
+			"data.txt",
+		}, walk("clients"))
+	})
+}
+
+func TestDirectoryGlob(t *testing.T) {
+	globs := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"*.txt", []string{"data.txt"}},
+		{"clients/*.txt", []string{"clients/a.txt", "clients/b.txt"}},
+		{"*/a.txt", []string{"clients/a.txt"}},
+		{"**/*.txt", []string{"clients/a.txt", "clients/b.txt", "data.txt"}},
+		{"**/clients", []string{"clients"}},
+		{"clients/**", []string{"clients", "clients/a.txt", "clients/b.txt"}},
+		{"invoices/*", nil},
+	}
+
+	runAccounting(t, func(s *testing.T, dir *Directory, onDisk bool) {
+		for _, glob := range globs {
+			matches, err := dir.Glob(glob.pattern)
+			if err != nil {
+				s.Fatal(err.Error())
+			}
+
+			assertStringSliceMatch(s, glob.expected, matches)
+		}
+
+		_, err := dir.Glob("clients/[")
+		if err != path.ErrBadPattern {
+			s.Errorf("expected path.ErrBadPattern, got %v", err)
+		}
+
+		// Glob should also be used by fs.Glob, as Directory is an
+		// fs.GlobFS.
+		matches, err := fs.Glob(dir, "clients/*")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "clients/a.txt clients/b.txt", strings.Join(matches, " "))
+	})
+}
@@ -0,0 +1,162 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+// As an exception, you may distribute programs that contain code generated
+// with or copied into by this program under terms of your choice.
+
+package zapped
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// WatchInterval is how often Watch checks the filesystem for changes.
+var WatchInterval = time.Second
+
+// ChangeOp describes what happened to a file that changed.
+type ChangeOp uint8
+
+// The ways in which a file can change.
+const (
+	Created ChangeOp = iota + 1
+	Modified
+	Deleted
+)
+
+// String returns the name of the operation.
+func (op ChangeOp) String() string {
+	switch op {
+	case Created:
+		return "created"
+	case Modified:
+		return "modified"
+	case Deleted:
+		return "deleted"
+	}
+
+	return "unknown"
+}
+
+// A Change describes a file that changed. The path is slash-separated and
+// relative to the Directory being watched.
+type Change struct {
+	Path string
+	Op   ChangeOp
+}
+
+// fileStamp records enough about a file to tell if it changed.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// snapshot records the stamp of every file on the filesystem beneath the root,
+// skipping the same files that zap skips when embedding. Files that can't be
+// read, such as those deleted while the snapshot is taken, are left out.
+func snapshot(root string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+
+	var walk func(name string)
+	walk = func(name string) {
+		entries, err := diskFS{root}.ReadDir(name)
+		if err != nil {
+			return
+		}
+
+		for _, entry := range entries {
+			fpath := joinPath(name, entry.Name())
+
+			if entry.IsDir() {
+				walk(fpath)
+				continue
+			}
+
+			info, err := entry.Info()
+			if err != nil {
+				continue
+			}
+
+			stamps[fpath] = fileStamp{size: info.Size(), modTime: info.ModTime()}
+		}
+	}
+
+	walk(".")
+	return stamps
+}
+
+// compareSnapshots returns the changes needed to turn one snapshot into
+// another, sorted by path.
+func compareSnapshots(before, after map[string]fileStamp) []Change {
+	var changes []Change
+
+	for name, stamp := range after {
+		previous, ok := before[name]
+
+		switch {
+		case !ok:
+			changes = append(changes, Change{Path: name, Op: Created})
+		case previous.size != stamp.size ||
+			!previous.modTime.Equal(stamp.modTime):
+			changes = append(changes, Change{Path: name, Op: Modified})
+		}
+	}
+
+	for name := range before {
+		if _, ok := after[name]; !ok {
+			changes = append(changes, Change{Path: name, Op: Deleted})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+
+	return changes
+}
+
+// Watch checks the files on the filesystem beneath the Directory for changes
+// every WatchInterval, calling fn with all of the changes found each time,
+// until the context is done. It blocks while watching, so will usually be
+// called in its own goroutine, and returns the error from the context once it
+// is done. Embedded files can't change, so if the Directory isn't being read
+// from the filesystem, Watch returns nil immediately.
+func (dir *Directory) Watch(ctx context.Context, fn func([]Change)) error {
+	if dir.diskPath == "" {
+		return nil
+	}
+
+	ticker := time.NewTicker(WatchInterval)
+	defer ticker.Stop()
+
+	previous := snapshot(dir.diskPath)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		current := snapshot(dir.diskPath)
+		if changes := compareSnapshots(previous, current); len(changes) != 0 {
+			fn(changes)
+		}
+
+		previous = current
+	}
+}
@@ -0,0 +1,161 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package zapped
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCompareSnapshots(t *testing.T) {
+	now := time.Now()
+
+	before := map[string]fileStamp{
+		"a.txt":         {size: 1, modTime: now},
+		"b.txt":         {size: 1, modTime: now},
+		"c.txt":         {size: 1, modTime: now},
+		"clients/d.txt": {size: 1, modTime: now},
+	}
+
+	after := map[string]fileStamp{
+		"a.txt":         {size: 1, modTime: now},
+		"b.txt":         {size: 2, modTime: now},
+		"clients/d.txt": {size: 1, modTime: now.Add(time.Second)},
+		"clients/e.txt": {size: 1, modTime: now},
+	}
+
+	expected := []Change{
+		{Path: "b.txt", Op: Modified},
+		{Path: "c.txt", Op: Deleted},
+		{Path: "clients/d.txt", Op: Modified},
+		{Path: "clients/e.txt", Op: Created},
+	}
+
+	changes := compareSnapshots(before, after)
+	if !reflect.DeepEqual(expected, changes) {
+		t.Errorf("Expected %v got %v", expected, changes)
+	}
+
+	if changes := compareSnapshots(after, after); len(changes) != 0 {
+		t.Errorf("Expected no changes got %v", changes)
+	}
+}
+
+func TestDirectoryWatch(t *testing.T) {
+	t.Run("Embedded", func(s *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		err := embeddedAccounting().Watch(ctx, func([]Change) {
+			s.Error("Expected no changes for an embedded directory")
+		})
+
+		if err != nil {
+			s.Errorf("Expected nil got %v", err)
+		}
+	})
+
+	t.Run("Filesystem", func(s *testing.T) {
+		previous := WatchInterval
+		WatchInterval = 10 * time.Millisecond
+		s.Cleanup(func() {
+			WatchInterval = previous
+		})
+
+		root := s.TempDir()
+		writeFiles(s, root, map[string]string{
+			"a.txt":         "A",
+			"b.txt":         "B",
+			"clients/c.txt": "C",
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		batches := make(chan []Change)
+		done := make(chan error)
+
+		go func() {
+			done <- (&Directory{diskPath: root}).Watch(ctx, func(c []Change) {
+				batches <- c
+			})
+		}()
+
+		// Give Watch time to take its first snapshot before changing anything.
+		time.Sleep(50 * time.Millisecond)
+
+		writeFiles(s, root, map[string]string{
+			"b.txt":         "Changed",
+			"clients/d.txt": "D",
+			".git/HEAD":     "ignored",
+		})
+
+		err := os.Remove(filepath.Join(root, "a.txt"))
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		expected := map[string]ChangeOp{
+			"a.txt":         Deleted,
+			"b.txt":         Modified,
+			"clients/d.txt": Created,
+		}
+
+		seen := make(map[string]ChangeOp)
+		timeout := time.After(5 * time.Second)
+
+		for len(seen) < len(expected) {
+			select {
+			case changes := <-batches:
+				// A poll can happen while a file is being written, so it
+				// may be seen as created and then modified. Only the first
+				// change to each file is kept.
+				for _, change := range changes {
+					if _, ok := seen[change.Path]; !ok {
+						seen[change.Path] = change.Op
+					}
+				}
+			case <-timeout:
+				s.Fatalf("Timed out waiting for changes, saw %v", seen)
+			}
+		}
+
+		if !reflect.DeepEqual(expected, seen) {
+			s.Errorf("Expected %v got %v", expected, seen)
+		}
+
+		cancel()
+
+		for {
+			select {
+			case <-batches:
+				continue
+			case err := <-done:
+				if !errors.Is(err, context.Canceled) {
+					s.Errorf("Expected context.Canceled got %v", err)
+				}
+			}
+
+			break
+		}
+	})
+}
@@ -20,8 +20,13 @@
 package zapped
 
 import (
+	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"io/ioutil"
+	"os"
 	"path"
 	"path/filepath"
 	"runtime"
@@ -29,105 +34,296 @@ import (
 
 // developmentMode indicates if the library has been run with the --dev flag,
 // which should allow the files to be read from the filesystem rather than from
-// the embedded source.
+// the embedded source. When it isn't set, resources can still be overlaid with
+// files from the filesystem, see SetOverlay.
 var developmentMode = true
 
-// A File represents an embedded file.
+// A File represents an embedded file. Along with its contents, the size, mode
+// and modification time of the file are recorded when it is embedded. The
+// contents are either held as they are, or compressed when zap found that made
+// them smaller, see Compressed. They may also be encrypted, see Unlock. The
+// hash is the SHA-256 of the contents that zap computed, encoded as
+// hexadecimal. The modification time is stored as nanoseconds since the Unix
+// epoch, with zero meaning that it was not recorded. The key and path identify
+// the file within its resource. If the file is being read from the filesystem
+// instead, diskPath will be set.
 type File struct {
-	contents []byte
+	contents   string
+	compressed *compressedContents
+	encrypted  *encryptedContents
+	size       int64
+	mode       fs.FileMode
+	modTime    int64
+	hash       string
+	key        string
+	path       string
+	diskPath   string
 }
 
-// Bytes return the contents of the file as a byte slice.
+// A Reader reads the contents of a File. As well as being read sequentially,
+// it can be seeked and read at arbitrary offsets. It should be closed once it
+// is no longer needed.
+type Reader interface {
+	io.ReadSeekCloser
+	io.ReaderAt
+}
+
+// Bytes return the contents of the file as a byte slice. If the file is being
+// read from the filesystem, the contents are read on each call. Like the
+// contents of an embedded file, Bytes can't fail, so nil is returned if the
+// contents can't be read, such as when they are still locked; callers that
+// need to know why should use Open or Directory.ReadFile, which return the
+// error.
 func (file *File) Bytes() []byte {
-	return file.contents
+	contents, err := file.read()
+	if err != nil {
+		return nil
+	}
+
+	return contents
+}
+
+// read returns the contents of the file, reading them from the filesystem if
+// that is where the file is. The caller owns the returned slice: decompressed
+// and decrypted contents are shared by every copy of the File, so they are
+// copied rather than returned directly.
+func (file *File) read() ([]byte, error) {
+	if file.diskPath != "" {
+		contents, err := ioutil.ReadFile(file.diskPath)
+		if err != nil {
+			return nil, file.pathError("read", err)
+		}
+
+		return contents, nil
+	}
+
+	contents, err := file.embeddedContents()
+	if err != nil {
+		return nil, file.pathError("read", err)
+	}
+
+	if file.compressed == nil && file.encrypted == nil {
+		return contents, nil
+	}
+
+	return append([]byte(nil), contents...), nil
 }
 
 // String returns the contents of the file as a string.
 func (file *File) String() string {
-	return string(file.contents)
+	return string(file.Bytes())
 }
 
-// A Directory represents an embedded directory.
+// Open returns a Reader for the contents of the file. If the file is being
+// read from the filesystem, the contents are streamed rather than being read
+// into memory all at once.
+func (file *File) Open() (Reader, error) {
+	if file.diskPath != "" {
+		f, err := os.Open(file.diskPath)
+		if err != nil {
+			return nil, file.pathError("open", err)
+		}
+
+		return f, nil
+	}
+
+	reader, err := file.embeddedReader()
+	if err != nil {
+		return nil, file.pathError("open", err)
+	}
+
+	return &openFile{
+		contentReader: reader,
+		info:          file.fileInfo(path.Base(file.path)),
+	}, nil
+}
+
+// Stat returns the information describing the file. If the file is being read
+// from the filesystem, the information is read from there, so that it is
+// always current.
+func (file *File) Stat() (fs.FileInfo, error) {
+	if file.diskPath != "" {
+		info, err := os.Stat(file.diskPath)
+		if err != nil {
+			return nil, file.pathError("stat", err)
+		}
+
+		return info, nil
+	}
+
+	return file.fileInfo(path.Base(file.path)), nil
+}
+
+// Hash returns the SHA-256 of the contents of the file, encoded as
+// hexadecimal. For embedded files this is the hash zap computed when embedding
+// them, so it can be used to check that the contents haven't changed since,
+// see Verify. If the file is being read from the filesystem, the hash is
+// computed from its current contents. Zap doesn't record the hash of encrypted
+// files, so theirs is computed from the decrypted contents, which fails with
+// ErrLocked until Unlock has been called.
+func (file *File) Hash() (string, error) {
+	if file.diskPath == "" && file.hash != "" {
+		return file.hash, nil
+	}
+
+	contents, err := file.read()
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(contents)), nil
+}
+
+// A Directory represents an embedded directory. The key and path identify the
+// directory within its resource. In development mode, or when the directory
+// has been overlaid, diskPath is the directory on the filesystem that is
+// checked before falling back to the embedded files.
 type Directory struct {
 	directories map[string]*Directory
 	files       map[string]File
-	devPath     string
+	key         string
+	path        string
+	diskPath    string
 }
 
-// File searches for a File with a name that matches the provided one. If a
-// file with the provided name cannot be found, an error will be returned.
-func (dir *Directory) File(name string) (File, error) {
-	var file File
+// embedded reports whether the Directory has any embedded contents, as opposed
+// to only being read from the filesystem.
+func (dir *Directory) embedded() bool {
+	return dir.directories != nil || dir.files != nil
+}
 
-	switch developmentMode {
-	case false:
-		f, ok := dir.files[name]
+// resolve finds the file or directory at the provided slash-separated path,
+// checking the filesystem first if the Directory has a diskPath, and falling
+// back to the embedded contents. Exactly one of the returned values will be
+// non-nil if no error occurs. The returned values carry the key and path they
+// were found at, so that errors can describe them.
+func (dir *Directory) resolve(name string) (*Directory, *File, error) {
+	var sub *Directory
+	var file *File
+
+	if dir.embedded() {
+		sub, file = dir.lookup(name)
+	}
 
-		if !ok {
-			err := fmt.Errorf("a file with name %s could not be found", name)
-			return File{}, err
+	fpath := joinPath(dir.path, name)
+	child := func(sub *Directory, diskPath string) *Directory {
+		resolved := &Directory{key: dir.key, path: fpath, diskPath: diskPath}
+		if sub != nil {
+			resolved.directories = sub.directories
+			resolved.files = sub.files
 		}
 
-		file = f
-	case true:
-		fpath := filepath.Join(dir.devPath, name)
-		bytes, err := ioutil.ReadFile(fpath)
+		return resolved
+	}
 
-		if err != nil {
-			return File{}, err
+	if file != nil {
+		embedded := *file
+		embedded.key = dir.key
+		embedded.path = fpath
+		file = &embedded
+	}
+
+	if dir.diskPath == "" {
+		switch {
+		case file != nil:
+			return nil, file, nil
+		case sub != nil:
+			return child(sub, ""), nil, nil
 		}
 
-		file = File{contents: bytes}
+		return nil, nil, ErrNotExist
+	}
+
+	dpath := filepath.Join(dir.diskPath, filepath.FromSlash(name))
+	info, err := diskFS{dir.diskPath}.Stat(name)
+
+	switch {
+	case err == nil && !info.IsDir():
+		return nil, &File{key: dir.key, path: fpath, diskPath: dpath}, nil
+	case err == nil || (sub != nil && errors.Is(err, ErrNotExist)):
+		return child(sub, dpath), nil, nil
+	case errors.Is(err, ErrNotExist) && file != nil:
+		return nil, file, nil
 	}
 
-	return file, nil
+	return nil, nil, err
 }
 
-// Directory searches for a Directory with a name that matches the provided
-// one. If a directory with a matching name cannot be found, an error will be
-// returned.
-func (dir *Directory) Directory(name string) (*Directory, error) {
-	var directory *Directory
+// File searches for a File at the provided slash-separated path relative to
+// the Directory. If a file cannot be found at the path, or the path is not
+// valid, a PathError will be returned.
+func (dir *Directory) File(name string) (File, error) {
+	if !validPath(name) {
+		return File{}, dir.pathError("open", name, ErrInvalid)
+	}
 
-	switch developmentMode {
-	case false:
-		dir, ok := dir.directories[name]
+	// The contents of files on the filesystem aren't read here, so that large
+	// files can be streamed with Open.
+	_, file, err := dir.resolve(name)
 
-		if !ok {
-			err := fmt.Errorf(
-				"a directory with name %s could not be found",
-				name)
+	switch {
+	case err != nil:
+		return File{}, dir.pathError("open", name, err)
+	case file == nil:
+		return File{}, dir.pathError("open", name, ErrInvalid)
+	}
 
-			return nil, err
-		}
+	return *file, nil
+}
 
-		directory = dir
-	case true:
-		directory = &Directory{devPath: filepath.Join(dir.devPath, name)}
+// Directory searches for a Directory at the provided slash-separated path
+// relative to the Directory. It is the same as calling Sub.
+func (dir *Directory) Directory(name string) (*Directory, error) {
+	return dir.Sub(name)
+}
+
+// Sub searches for a Directory at the provided slash-separated path relative
+// to the Directory. If a directory cannot be found at the path, or the path is
+// not valid, a PathError will be returned.
+func (dir *Directory) Sub(name string) (*Directory, error) {
+	if !validPath(name) {
+		return nil, dir.pathError("sub", name, ErrInvalid)
 	}
 
-	return directory, nil
+	sub, _, err := dir.resolve(name)
+
+	switch {
+	case err != nil:
+		return nil, dir.pathError("sub", name, err)
+	case sub == nil:
+		return nil, dir.pathError("sub", name, ErrInvalid)
+	}
+
+	return sub, nil
 }
 
-// Files returns the names of all files embedded into the Directory sorted in
-// alphabetical order.
+// Files returns the names of all files in the Directory sorted in alphabetical
+// order. Files on the filesystem are listed alongside the embedded ones,
+// skipping the same files that zap skips when embedding.
 func (dir *Directory) Files() []string {
 	var files []string
 
-	for fname := range dir.files {
-		files = append(files, fname)
+	entries, _ := dir.entries()
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			files = append(files, entry.Name())
+		}
 	}
 
 	return files
 }
 
-// Directories returns the names of all directories embedded into the Directory
-// sorted in alphabetical order.
+// Directories returns the names of all directories in the Directory sorted in
+// alphabetical order. Directories on the filesystem are listed alongside the
+// embedded ones, skipping the same directories that zap skips when embedding.
 func (dir *Directory) Directories() []string {
 	var directories []string
 
-	for dname := range dir.directories {
-		directories = append(directories, dname)
+	entries, _ := dir.entries()
+	for _, entry := range entries {
+		if entry.IsDir() {
+			directories = append(directories, entry.Name())
+		}
 	}
 
 	return directories
@@ -139,7 +335,10 @@ func (dir *Directory) Directories() []string {
 var resources = make(map[string]*Directory)
 
 // Resource attempts to locate an embedded resource with the provided key, and
-// return it. If the resource cannot be found, an error will be returned.
+// return it. If the resource cannot be found, a PathError wrapping
+// ErrUnknownResource will be returned. If an overlay has been configured for
+// the key, files on the filesystem beneath the overlay root will be used in
+// place of the embedded ones.
 func Resource(key string, dir string) (*Directory, error) {
 	var resource *Directory
 
@@ -148,21 +347,91 @@ func Resource(key string, dir string) (*Directory, error) {
 		res, ok := resources[key]
 
 		if !ok {
-			return nil, fmt.Errorf(
-				"resource with key %s could not be found",
-				key)
+			err := &PathError{Op: "resource", Key: key, Err: ErrUnknownResource}
+			return nil, err
 		}
 
-		resource = res
+		resource = &Directory{
+			directories: res.directories,
+			files:       res.files,
+			key:         key,
+			diskPath:    overlayRoot(key),
+		}
 	case true:
 		_, fn, _, ok := runtime.Caller(1)
 		if !ok {
-			return nil, fmt.Errorf(
-				"was unable to get relative path for directory %s",
-				dir)
+			err := &PathError{Op: "resource", Key: key, Err: errUnknownCaller}
+			return nil, err
 		}
 
-		resource = &Directory{devPath: filepath.Join(path.Dir(fn), dir)}
+		resource = &Directory{
+			key:      key,
+			diskPath: filepath.Join(path.Dir(fn), dir),
+		}
+	}
+
+	return resource, nil
+}
+
+// fileResources is used to store all of the single files that are embedded
+// within the application. The string used to refer to them is the Key provided
+// to the call to ResourceFile().
+var fileResources = make(map[string]*File)
+
+// ResourceFile attempts to locate an embedded file with the provided key, and
+// return it. Unlike Resource, only the single file is embedded, rather than
+// the whole directory it is in. If the file cannot be found, a PathError
+// wrapping ErrUnknownResource will be returned in embedded mode, or one
+// wrapping ErrNotExist in development mode. If an overlay has been configured
+// for the key, it names a file on the filesystem that will be used in place
+// of the embedded one when it exists.
+func ResourceFile(key string, file string) (File, error) {
+	var resource File
+
+	switch developmentMode {
+	case false:
+		res, ok := fileResources[key]
+
+		if !ok {
+			err := &PathError{Op: "resource", Key: key, Err: ErrUnknownResource}
+			return File{}, err
+		}
+
+		resource = *res
+		resource.key = key
+		resource.path = path.Base(filepath.ToSlash(file))
+
+		if root := overlayRoot(key); root != "" {
+			info, err := os.Stat(root)
+
+			switch {
+			case err == nil && !info.IsDir():
+				resource.diskPath = root
+			case err != nil && !errors.Is(err, ErrNotExist):
+				return File{}, resource.pathError("resource", err)
+			}
+		}
+	case true:
+		_, fn, _, ok := runtime.Caller(1)
+		if !ok {
+			err := &PathError{Op: "resource", Key: key, Err: errUnknownCaller}
+			return File{}, err
+		}
+
+		resource = File{
+			key:      key,
+			path:     path.Base(filepath.ToSlash(file)),
+			diskPath: filepath.Join(path.Dir(fn), file),
+		}
+
+		info, err := os.Stat(resource.diskPath)
+
+		switch {
+		case err != nil:
+			return File{}, resource.pathError("resource", err)
+		case info.IsDir():
+			return File{}, resource.pathError("resource", ErrInvalid)
+		}
 	}
 
 	return resource, nil
@@ -0,0 +1,294 @@
+// This file is part of Zap, a tool for embedding files into Go source.
+// Copyright (C) 2020 Jordan Ocokoljic.
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+package zapped
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStat(t *testing.T) {
+	t.Run("Embedded", func(s *testing.T) {
+		file, err := embeddedAccounting().File("data.txt")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "data.txt", info.Name())
+		assertString(s, "-rw-r--r--", info.Mode().String())
+
+		if info.Size() != 43 {
+			s.Errorf("expected size 43, got %d", info.Size())
+		}
+
+		if !info.ModTime().Equal(time.Unix(0, 1590192000000000000)) {
+			s.Errorf("unexpected modification time %v", info.ModTime())
+		}
+	})
+
+	t.Run("Development", func(s *testing.T) {
+		dir := &Directory{diskPath: accountingPath}
+		file, err := dir.File("data.txt")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		info, err := file.Stat()
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		expected, err := os.Stat(filepath.Join(accountingPath, "data.txt"))
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "data.txt", info.Name())
+
+		if info.Size() != expected.Size() || info.Mode() != expected.Mode() {
+			s.Errorf("expected %v, got %v", expected, info)
+		}
+
+		if !info.ModTime().Equal(expected.ModTime()) {
+			s.Errorf("unexpected modification time %v", info.ModTime())
+		}
+	})
+}
+
+func TestDirectoryNestedPaths(t *testing.T) {
+	runAccounting(t, func(s *testing.T, dir *Directory, onDisk bool) {
+		file, err := dir.File("clients/a.txt")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "AccountName: A\nBalance: 243512.34", file.String())
+
+		body, err := dir.ReadFile("clients/b.txt")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "AccountName: B\nBalance: 748362.34", string(body))
+
+		sub, err := dir.Sub("clients")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		file, err = sub.File("b.txt")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "AccountName: B\nBalance: 748362.34", file.String())
+
+		if _, err := dir.Sub("data.txt"); err == nil {
+			s.Error("expected an error using a file as a directory")
+		}
+
+		if _, err := dir.Sub("invoices"); err == nil {
+			s.Error("expected an error for a missing directory")
+		}
+
+		invalid := []string{
+			"../accounting/data.txt",
+			"/clients/a.txt",
+			"clients\\a.txt",
+			"clients/../data.txt",
+			"./data.txt",
+			"clients/",
+		}
+
+		for _, name := range invalid {
+			if _, err := dir.File(name); err == nil {
+				s.Errorf("expected File to reject %s", name)
+			}
+
+			if _, err := dir.ReadFile(name); err == nil {
+				s.Errorf("expected ReadFile to reject %s", name)
+			}
+
+			if _, err := dir.Open(name); err == nil {
+				s.Errorf("expected Open to reject %s", name)
+			}
+
+			if _, err := dir.Sub(name); err == nil {
+				s.Errorf("expected Sub to reject %s", name)
+			}
+		}
+	})
+}
+
+func TestFileOpen(t *testing.T) {
+	runAccounting(t, func(s *testing.T, dir *Directory, onDisk bool) {
+		file, err := dir.File("clients/a.txt")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		reader, err := file.Open()
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		defer reader.Close()
+
+		if _, ok := reader.(*os.File); ok != onDisk {
+			s.Errorf("expected streaming from disk to be %t", onDisk)
+		}
+
+		body, err := ioutil.ReadAll(reader)
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "AccountName: A\nBalance: 243512.34", string(body))
+
+		if _, err := reader.Seek(13, io.SeekStart); err != nil {
+			s.Fatal(err.Error())
+		}
+
+		buf := make([]byte, 1)
+		if _, err := reader.Read(buf); err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "A", string(buf))
+
+		buf = make([]byte, 9)
+		if _, err := reader.ReadAt(buf, 24); err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "243512.34", string(buf))
+	})
+}
+
+func TestDirectoryListings(t *testing.T) {
+	runAccounting(t, func(s *testing.T, dir *Directory, onDisk bool) {
+		// The generated zap.embed.go and zap.embed.<key>.go files are
+		// skipped in development mode, the same as when embedding.
+		assertStringSliceMatch(s, []string{"data.txt"}, dir.Files())
+		assertStringSliceMatch(s, []string{"clients"}, dir.Directories())
+
+		for _, name := range []string{"zap.embed.go", "zap.embed.accounting.go"} {
+			if _, err := dir.File(name); err == nil {
+				s.Errorf("expected %s to be skipped", name)
+			}
+		}
+
+		clients, err := dir.Directory("clients")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertStringSliceMatch(s, []string{"a.txt", "b.txt"}, clients.Files())
+		assertStringSliceMatch(s, nil, clients.Directories())
+	})
+}
+
+func TestResourceFile(t *testing.T) {
+	const body = "AccountName: jordanockoljic\nBalance: 143.50"
+
+	t.Run("Embedded", func(s *testing.T) {
+		setDevelopmentMode(s, false)
+
+		embedded := embeddedFile(body)
+		fileResources["DATA"] = &embedded
+		s.Cleanup(func() {
+			delete(fileResources, "DATA")
+		})
+
+		file, err := ResourceFile("DATA", "../testdata/accounting/data.txt")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, body, file.String())
+
+		info, err := file.Stat()
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "data.txt", info.Name())
+
+		_, err = ResourceFile("MISSING", "missing.txt")
+		assertPathError(s, "MISSING", "", ErrUnknownResource, err)
+	})
+
+	t.Run("Overlay", func(s *testing.T) {
+		setDevelopmentMode(s, false)
+
+		embedded := embeddedFile(body)
+		fileResources["DATA"] = &embedded
+		s.Cleanup(func() {
+			delete(fileResources, "DATA")
+		})
+
+		root := s.TempDir()
+		writeFiles(s, root, map[string]string{"data.txt": "Overlaid"})
+
+		SetOverlay("DATA", filepath.Join(root, "data.txt"))
+		s.Cleanup(func() {
+			SetOverlay("DATA", "")
+		})
+
+		file, err := ResourceFile("DATA", "../testdata/accounting/data.txt")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, "Overlaid", file.String())
+
+		SetOverlay("DATA", filepath.Join(root, "missing.txt"))
+
+		file, err = ResourceFile("DATA", "../testdata/accounting/data.txt")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, body, file.String())
+	})
+
+	t.Run("Development", func(s *testing.T) {
+		setDevelopmentMode(s, true)
+
+		file, err := ResourceFile("DATA", "../testdata/accounting/data.txt")
+		if err != nil {
+			s.Fatal(err.Error())
+		}
+
+		assertString(s, body, file.String())
+
+		_, err = ResourceFile("DATA", "../testdata/accounting/missing.txt")
+		assertPathError(s, "DATA", "missing.txt", ErrNotExist, err)
+
+		_, err = ResourceFile("DATA", "../testdata/accounting")
+		assertPathError(s, "DATA", "accounting", ErrInvalid, err)
+	})
+}